@@ -0,0 +1,284 @@
+package wbgong
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DeviceAvailability is an online/offline state of device
+type DeviceAvailability int
+
+const (
+	AvailabilityUnknown DeviceAvailability = iota
+	AvailabilityOnline
+	AvailabilityOffline
+)
+
+func (a DeviceAvailability) String() string {
+	switch a {
+	case AvailabilityOnline:
+		return CONV_AVAILABILITY_ONLINE
+	case AvailabilityOffline:
+		return CONV_AVAILABILITY_OFFLINE
+	default:
+		return ""
+	}
+}
+
+// ParseDeviceAvailability parses availability meta payload,
+// unknown payloads give AvailabilityUnknown
+func ParseDeviceAvailability(payload string) DeviceAvailability {
+	switch payload {
+	case CONV_AVAILABILITY_ONLINE:
+		return AvailabilityOnline
+	case CONV_AVAILABILITY_OFFLINE:
+		return AvailabilityOffline
+	default:
+		return AvailabilityUnknown
+	}
+}
+
+// AvailabilityControlError returns error which must be set to controls
+// of device with given availability: read error for offline devices, nil otherwise
+func AvailabilityControlError(a DeviceAvailability) ControlError {
+	if a == AvailabilityOffline {
+		return NewMetaError(ErrorFlagRead)
+	}
+	return nil
+}
+
+// SetLocalDeviceAvailability sets local device availability and adds read error flag
+// to /meta/error of all device controls while device is offline
+// (flag is removed when device is back online).
+// Must be called in driver loop
+func SetLocalDeviceAvailability(dev LocalDevice, a DeviceAvailability) error {
+	if err := dev.SetAvailability(a)(); err != nil {
+		return err
+	}
+	for _, ctrl := range dev.ControlsList() {
+		cur := ToMetaError(ctrl.GetError())
+		next := cur.Without(ErrorFlagRead)
+		if AvailabilityControlError(a) != nil {
+			next = cur.With(ErrorFlagRead)
+		}
+		if next == cur {
+			continue
+		}
+		if err := ctrl.SetError(next.ToError())(); err != nil {
+			return fmt.Errorf("control %s/%s: %w", dev.GetId(), ctrl.GetId(), err)
+		}
+	}
+	return nil
+}
+
+// DriverAvailabilityMessage returns retained message with driver availability.
+// Driver availability is published as availability meta of device named after driver
+// (/devices/<driver>/meta/availability), so it shares conventions with devices.
+// Message with AvailabilityOffline must be used as driver MQTT client's last will,
+// see NewPahoMQTTClientWithWill
+func DriverAvailabilityMessage(driverID string, a DeviceAvailability) MQTTMessage {
+	return MQTTMessage{
+		Topic:    fmt.Sprintf(CONV_DEVICE_META_FMT, driverID, CONV_META_SUBTOPIC_AVAILABILITY),
+		Payload:  a.String(),
+		QoS:      1,
+		Retained: true,
+	}
+}
+
+type trackedAvailability struct {
+	own      DeviceAvailability
+	driverID string
+}
+
+// AvailabilityTracker calculates effective availability of external devices
+// from their availability meta and availability of drivers owning them.
+// Device is offline if it says so or if its driver is offline (driver's last will received).
+// Driver availability is the availability meta of device named after driver
+type AvailabilityTracker struct {
+	mu       sync.Mutex
+	devices  map[string]*trackedAvailability
+	drivers  map[string]DeviceAvailability
+	onChange func(deviceID string, a, prev DeviceAvailability)
+	// availability meta received for IDs which are not known drivers yet
+	received map[string]DeviceAvailability
+}
+
+// NewAvailabilityTracker creates tracker, onChange is called (without tracker lock held)
+// on every effective availability change
+func NewAvailabilityTracker(onChange func(deviceID string, a, prev DeviceAvailability)) *AvailabilityTracker {
+	return &AvailabilityTracker{
+		devices:  make(map[string]*trackedAvailability),
+		drivers:  make(map[string]DeviceAvailability),
+		onChange: onChange,
+		received: make(map[string]DeviceAvailability),
+	}
+}
+
+// Topics returns topics to subscribe to receive availability information
+func (t *AvailabilityTracker) Topics() []string {
+	return []string{
+		fmt.Sprintf(CONV_DEVICE_META_FMT, CONV_SUBTOPIC_ALL, CONV_META_SUBTOPIC_AVAILABILITY),
+		fmt.Sprintf(CONV_DEVICE_META_FMT, CONV_SUBTOPIC_ALL, CONV_META_SUBTOPIC_DRIVER),
+	}
+}
+
+// HandleMessage updates tracker state from MQTT message, unrelated messages are ignored.
+// Availability meta is treated as driver availability if ID is a known driver
+// (some device has it in meta/driver), otherwise it's availability of device itself
+func (t *AvailabilityTracker) HandleMessage(msg MQTTMessage) {
+	parts := strings.Split(msg.Topic, "/")
+	if len(parts) != 5 || parts[1] != "devices" || parts[3] != "meta" {
+		return
+	}
+	id := parts[2]
+	switch parts[4] {
+	case CONV_META_SUBTOPIC_AVAILABILITY:
+		a := ParseDeviceAvailability(msg.Payload)
+		t.update(func() []string {
+			if t.isDriver(id) {
+				return t.driverDevices(id)
+			}
+			return []string{id}
+		}, func() {
+			t.received[id] = a
+			if t.isDriver(id) {
+				t.drivers[id] = a
+			} else {
+				t.device(id).own = a
+			}
+		})
+	case CONV_META_SUBTOPIC_DRIVER:
+		t.SetDeviceDriver(id, msg.Payload)
+	}
+}
+
+// isDriver checks whether some device is owned by driver, must be called with lock held
+func (t *AvailabilityTracker) isDriver(id string) bool {
+	for _, dev := range t.devices {
+		if dev.driverID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *AvailabilityTracker) effective(deviceID string) DeviceAvailability {
+	dev, ok := t.devices[deviceID]
+	if !ok {
+		return AvailabilityUnknown
+	}
+	driver := t.drivers[dev.driverID]
+	switch {
+	case driver == AvailabilityOffline:
+		return AvailabilityOffline
+	case dev.own != AvailabilityUnknown:
+		return dev.own
+	default:
+		return driver
+	}
+}
+
+// update applies change with lock held and notifies about availability changes
+// of affected devices
+func (t *AvailabilityTracker) update(affected func() []string, apply func()) {
+	t.mu.Lock()
+	ids := affected()
+	before := make(map[string]DeviceAvailability, len(ids))
+	for _, id := range ids {
+		before[id] = t.effective(id)
+	}
+	apply()
+	after := make(map[string]DeviceAvailability, len(ids))
+	for _, id := range ids {
+		after[id] = t.effective(id)
+	}
+	t.mu.Unlock()
+
+	if t.onChange == nil {
+		return
+	}
+	for _, id := range ids {
+		if after[id] != before[id] {
+			t.onChange(id, after[id], before[id])
+		}
+	}
+}
+
+// driverDevices returns IDs of devices owned by driver, must be called with lock held
+func (t *AvailabilityTracker) driverDevices(driverID string) []string {
+	ids := make([]string, 0)
+	for id, dev := range t.devices {
+		if dev.driverID == driverID && id != driverID {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (t *AvailabilityTracker) device(deviceID string) *trackedAvailability {
+	dev, ok := t.devices[deviceID]
+	if !ok {
+		dev = &trackedAvailability{}
+		t.devices[deviceID] = dev
+	}
+	return dev
+}
+
+// SetDeviceAvailability sets availability published by device itself
+func (t *AvailabilityTracker) SetDeviceAvailability(deviceID string, a DeviceAvailability) {
+	t.update(func() []string {
+		return []string{deviceID}
+	}, func() {
+		t.device(deviceID).own = a
+	})
+}
+
+// SetDeviceDriver sets ID of driver owning device.
+// Availability meta received before for driver ID becomes driver availability
+func (t *AvailabilityTracker) SetDeviceDriver(deviceID, driverID string) {
+	t.update(func() []string {
+		return append(t.driverDevices(driverID), deviceID)
+	}, func() {
+		t.device(deviceID).driverID = driverID
+		if driverID == "" {
+			return
+		}
+		if _, ok := t.drivers[driverID]; ok {
+			return
+		}
+		if a, ok := t.received[driverID]; ok {
+			t.drivers[driverID] = a
+			// driver was tracked as device before its devices became known
+			if dev, ok := t.devices[driverID]; ok && dev.driverID == "" {
+				delete(t.devices, driverID)
+			}
+		}
+	})
+}
+
+// SetDriverAvailability sets driver availability, it affects all devices of this driver
+func (t *AvailabilityTracker) SetDriverAvailability(driverID string, a DeviceAvailability) {
+	t.update(func() []string {
+		return t.driverDevices(driverID)
+	}, func() {
+		t.drivers[driverID] = a
+	})
+}
+
+// RemoveDevice forgets device
+func (t *AvailabilityTracker) RemoveDevice(deviceID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.devices, deviceID)
+	if !t.isDriver(deviceID) {
+		delete(t.received, deviceID)
+	}
+}
+
+// Get returns effective device availability
+func (t *AvailabilityTracker) Get(deviceID string) DeviceAvailability {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.effective(deviceID)
+}
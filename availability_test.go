@@ -0,0 +1,50 @@
+package wbgong
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func availabilityMsg(id, key, payload string) MQTTMessage {
+	return MQTTMessage{Topic: fmt.Sprintf(CONV_DEVICE_META_FMT, id, key), Payload: payload, Retained: true}
+}
+
+func TestAvailabilityTracker(t *testing.T) {
+	var changes []string
+	tracker := NewAvailabilityTracker(func(id string, a, prev DeviceAvailability) {
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", id, prev, a))
+	})
+
+	// driver availability arrives before devices of driver are known
+	tracker.HandleMessage(DriverAvailabilityMessage("drv", AvailabilityOnline))
+	tracker.HandleMessage(availabilityMsg("dev1", CONV_META_SUBTOPIC_DRIVER, "drv"))
+	tracker.HandleMessage(availabilityMsg("dev2", CONV_META_SUBTOPIC_DRIVER, "drv"))
+	tracker.HandleMessage(availabilityMsg("dev2", CONV_META_SUBTOPIC_AVAILABILITY, CONV_AVAILABILITY_ONLINE))
+	assert.Equal(t, AvailabilityOnline, tracker.Get("dev1"))
+	assert.Equal(t, AvailabilityOnline, tracker.Get("dev2"))
+	assert.Equal(t, AvailabilityUnknown, tracker.Get("drv"), "driver is not tracked as device")
+
+	// device availability doesn't affect other devices
+	changes = nil
+	tracker.HandleMessage(availabilityMsg("dev2", CONV_META_SUBTOPIC_AVAILABILITY, CONV_AVAILABILITY_OFFLINE))
+	assert.Equal(t, []string{"dev2: online -> offline"}, changes)
+	assert.Equal(t, AvailabilityOnline, tracker.Get("dev1"))
+
+	// driver's last will takes all its devices offline
+	changes = nil
+	tracker.HandleMessage(DriverAvailabilityMessage("drv", AvailabilityOffline))
+	assert.Equal(t, []string{"dev1: online -> offline"}, changes)
+
+	changes = nil
+	tracker.HandleMessage(DriverAvailabilityMessage("drv", AvailabilityOnline))
+	assert.ElementsMatch(t, []string{"dev1: offline -> online"}, changes)
+	assert.Equal(t, AvailabilityOffline, tracker.Get("dev2"), "device's own availability is kept")
+
+	// device without driver meta
+	tracker.HandleMessage(availabilityMsg("dev3", CONV_META_SUBTOPIC_AVAILABILITY, CONV_AVAILABILITY_OFFLINE))
+	assert.Equal(t, AvailabilityOffline, tracker.Get("dev3"))
+	tracker.RemoveDevice("dev3")
+	assert.Equal(t, AvailabilityUnknown, tracker.Get("dev3"))
+}
@@ -0,0 +1,141 @@
+package wbgong
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// ControlValueUpdate is a single control value change in ControlBatch
+type ControlValueUpdate struct {
+	Control      Control
+	RawValue     string
+	PrevRawValue string
+}
+
+// ControlMetaUpdate is a single control meta change in ControlBatch
+type ControlMetaUpdate struct {
+	Control Control
+	Meta    string
+	Value   any
+}
+
+// ControlBatch is a set of value and meta changes of controls of single local device
+// applied as one unit (see LocalDevice.ApplyBatch).
+// Local handlers get ControlBatchEvent followed by ControlValueEvent for every value change
+// marked with batch ID (see Events), backend publishes all changes back to back
+type ControlBatch struct {
+	Device LocalDevice
+	Values []ControlValueUpdate
+	Metas  []ControlMetaUpdate
+}
+
+// NewControlBatch creates empty batch for given device
+func NewControlBatch(dev LocalDevice) *ControlBatch {
+	return &ControlBatch{
+		Device: dev,
+	}
+}
+
+func (b *ControlBatch) checkControl(control Control) error {
+	if control == nil {
+		return NoSuchControlError
+	}
+	if control.IsDeleted() {
+		return ControlDeletedError
+	}
+	if control.GetDevice() == nil || control.GetDevice().GetId() != b.Device.GetId() {
+		return fmt.Errorf("%w: control %s doesn't belong to device %s", ControlArgumentsError,
+			control.GetId(), b.Device.GetId())
+	}
+	return nil
+}
+
+// SetRawValue adds value change to batch.
+// If control is already in batch, its new value replaces previous one
+func (b *ControlBatch) SetRawValue(control Control, rawValue string) error {
+	if err := b.checkControl(control); err != nil {
+		return err
+	}
+	for i := range b.Values {
+		if b.Values[i].Control == control {
+			b.Values[i].RawValue = rawValue
+			return nil
+		}
+	}
+	b.Values = append(b.Values, ControlValueUpdate{
+		Control:      control,
+		RawValue:     rawValue,
+		PrevRawValue: control.GetRawValue(),
+	})
+	return nil
+}
+
+// SetValue converts value according to control type and adds it to batch
+func (b *ControlBatch) SetValue(control Control, value any) error {
+	if err := b.checkControl(control); err != nil {
+		return err
+	}
+	raw, err := ToRawValue(value, control.GetType())
+	if err != nil {
+		return err
+	}
+	return b.SetRawValue(control, raw)
+}
+
+// SetMeta adds control meta change to batch
+func (b *ControlBatch) SetMeta(control Control, meta string, value any) error {
+	if err := b.checkControl(control); err != nil {
+		return err
+	}
+	b.Metas = append(b.Metas, ControlMetaUpdate{
+		Control: control,
+		Meta:    meta,
+		Value:   value,
+	})
+	return nil
+}
+
+// Len returns number of changes in batch
+func (b *ControlBatch) Len() int {
+	return len(b.Values) + len(b.Metas)
+}
+
+// lastBatchId is a source of ControlBatchEvent IDs
+var lastBatchId atomic.Uint64
+
+// Events returns events to be delivered to local handlers when batch is applied:
+// ControlBatchEvent for handlers interested in whole batch and then ControlValueEvent
+// for every value change, so per-control handlers see batched values too.
+// All events share new batch ID, so handlers which process ControlBatchEvent
+// may skip ControlValueEvents with the same BatchId
+func (b *ControlBatch) Events() []DriverEvent {
+	id := lastBatchId.Add(1)
+	values := b.ValueEvents()
+	for i := range values {
+		values[i].BatchId = id
+	}
+	events := make([]DriverEvent, 0, len(values)+1)
+	events = append(events, ControlBatchEvent{
+		Id:     id,
+		Device: b.Device,
+		Values: values,
+		Metas:  b.Metas,
+	})
+	for _, e := range values {
+		events = append(events, e)
+	}
+	return events
+}
+
+// ValueEvents returns ControlValueEvent for every value change in batch, BatchId is not set
+func (b *ControlBatch) ValueEvents() []ControlValueEvent {
+	events := make([]ControlValueEvent, len(b.Values))
+	for i, v := range b.Values {
+		events[i] = ControlValueEvent{
+			Control:      v.Control,
+			RawValue:     v.RawValue,
+			PrevRawValue: v.PrevRawValue,
+		}
+	}
+	return events
+}
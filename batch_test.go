@@ -0,0 +1,44 @@
+package wbgong
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type batchControl struct {
+	routerControl
+	value string
+}
+
+func (c *batchControl) IsDeleted() bool     { return false }
+func (c *batchControl) GetRawValue() string { return c.value }
+
+func TestControlBatchEvents(t *testing.T) {
+	dev := &routerLocalDevice{id: "dev"}
+	k1 := &batchControl{routerControl{dev: dev, id: "K1"}, "0"}
+	k2 := &batchControl{routerControl{dev: dev, id: "K2"}, "0"}
+	other := &batchControl{routerControl{dev: &routerDevice{id: "other"}, id: "K1"}, "0"}
+
+	b := NewControlBatch(dev)
+	require.NoError(t, b.SetRawValue(k1, "1"))
+	require.NoError(t, b.SetRawValue(k2, "1"))
+	require.NoError(t, b.SetRawValue(k1, "2"))
+	assert.ErrorIs(t, b.SetRawValue(other, "1"), ControlArgumentsError)
+	assert.Equal(t, 2, b.Len())
+
+	events := b.Events()
+	require.Len(t, events, 3)
+	batch := events[0].(ControlBatchEvent)
+	assert.NotZero(t, batch.Id)
+	assert.Equal(t, []ControlValueEvent{
+		{Control: k1, RawValue: "2", PrevRawValue: "0", BatchId: batch.Id},
+		{Control: k2, RawValue: "1", PrevRawValue: "0", BatchId: batch.Id},
+	}, batch.Values)
+	assert.Equal(t, batch.Values[0], events[1])
+	assert.Equal(t, batch.Values[1], events[2])
+
+	next := b.Events()[0].(ControlBatchEvent)
+	assert.NotEqual(t, batch.Id, next.Id, "every applied batch gets new ID")
+}
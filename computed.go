@@ -0,0 +1,268 @@
+package wbgong
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ComputeFunc calculates computed control value from its sources values.
+// Values are passed converted according to source controls types
+type ComputeFunc func(values map[string]any) (any, error)
+
+// ComputedValue describes how computed control value is derived
+// from other controls. Either Expr or Func must be set
+type ComputedValue struct {
+	// Sources maps names used in Expr (or keys passed to Func) to source controls
+	Sources map[string]DeviceControlPair
+
+	// Expr is an expression to calculate value, see CompileExpr
+	Expr string
+
+	// Func calculates value, used if Expr is empty
+	Func ComputeFunc
+
+	// Debounce delays recalculation until sources stop changing for given interval
+	Debounce time.Duration
+}
+
+type computedControl struct {
+	control Control
+	pair    DeviceControlPair
+	spec    *ComputedValue
+	expr    *Expr
+	timer   *time.Timer
+	// failed is accessed in driver loop only
+	failed bool
+}
+
+// ComputeEngine recalculates computed controls when their sources change.
+// Driver registers controls created with ControlArgs.SetComputed in its engine
+type ComputeEngine struct {
+	mu        sync.Mutex
+	driver    DeviceDriver
+	handlerID HandlerID
+	computed  map[DeviceControlPair]*computedControl
+	// source control -> computed controls depending on it
+	dependents map[DeviceControlPair][]*computedControl
+}
+
+// NewComputeEngine creates compute engine and attaches it to driver
+func NewComputeEngine(driver DeviceDriver) *ComputeEngine {
+	e := &ComputeEngine{
+		driver:     driver,
+		computed:   make(map[DeviceControlPair]*computedControl),
+		dependents: make(map[DeviceControlPair][]*computedControl),
+	}
+	e.handlerID = driver.OnDriverEvent(e.handleEvent)
+	return e
+}
+
+// Close detaches engine from driver and stops pending recalculations
+func (e *ComputeEngine) Close() {
+	e.driver.RemoveOnDriverEventHandler(e.handlerID)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, c := range e.computed {
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+	}
+}
+
+// Register registers local control as computed one.
+// ComputedCycleError is returned if control depends on itself (directly or via other computed controls).
+// Initial value is calculated asynchronously in driver loop
+func (e *ComputeEngine) Register(control Control, spec *ComputedValue) error {
+	if spec == nil || (spec.Expr == "" && spec.Func == nil) {
+		return fmt.Errorf("%w: computed control needs expression or function", ControlArgumentsError)
+	}
+	c := &computedControl{
+		control: control,
+		pair:    NewDeviceControlPair(control.GetDevice().GetId(), control.GetId()),
+		spec:    spec,
+	}
+	if spec.Expr != "" {
+		expr, err := CompileExpr(spec.Expr)
+		if err != nil {
+			return err
+		}
+		for _, v := range expr.Vars() {
+			if _, ok := spec.Sources[v]; !ok {
+				return fmt.Errorf("%w: '%s' in '%s'", ExprUnknownVariableError, v, spec.Expr)
+			}
+		}
+		c.expr = expr
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// existing registration is kept if new one makes a cycle
+	for _, src := range spec.Sources {
+		if src == c.pair || e.dependsOn(src, c.pair) {
+			return fmt.Errorf("%w: %s/%s", ComputedCycleError, c.pair.deviceID, c.pair.controlID)
+		}
+	}
+	if _, ok := e.computed[c.pair]; ok {
+		e.unregister(c.pair)
+	}
+	e.computed[c.pair] = c
+	for _, src := range spec.Sources {
+		e.dependents[src] = append(e.dependents[src], c)
+	}
+
+	e.driver.AccessAsync(func(tx DriverTx) error {
+		e.recompute(c, tx)
+		return nil
+	})
+	return nil
+}
+
+// dependsOn checks whether control 'what' is calculated from 'src' (transitively).
+// Must be called with lock held
+func (e *ComputeEngine) dependsOn(what, src DeviceControlPair) bool {
+	visited := make(map[DeviceControlPair]bool)
+	queue := []DeviceControlPair{src}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if cur == what {
+			return true
+		}
+		if visited[cur] {
+			continue
+		}
+		visited[cur] = true
+		for _, dep := range e.dependents[cur] {
+			queue = append(queue, dep.pair)
+		}
+	}
+	return false
+}
+
+// Unregister stops recalculation of given control
+func (e *ComputeEngine) Unregister(control Control) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.unregister(NewDeviceControlPair(control.GetDevice().GetId(), control.GetId()))
+}
+
+func (e *ComputeEngine) unregister(pair DeviceControlPair) {
+	c, ok := e.computed[pair]
+	if !ok {
+		return
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	delete(e.computed, pair)
+	for _, src := range c.spec.Sources {
+		deps := e.dependents[src]
+		for i, dep := range deps {
+			if dep == c {
+				deps = append(deps[:i], deps[i+1:]...)
+				break
+			}
+		}
+		if len(deps) == 0 {
+			delete(e.dependents, src)
+		} else {
+			e.dependents[src] = deps
+		}
+	}
+}
+
+func (e *ComputeEngine) handleEvent(event DriverEvent) {
+	ev, ok := event.(ControlValueEvent)
+	if !ok {
+		return
+	}
+	pair := NewDeviceControlPair(ev.Control.GetDevice().GetId(), ev.Control.GetId())
+
+	e.mu.Lock()
+	deps := append([]*computedControl(nil), e.dependents[pair]...)
+	e.mu.Unlock()
+
+	for _, c := range deps {
+		if c.control.IsDeleted() {
+			e.Unregister(c.control)
+			continue
+		}
+		if c.spec.Debounce <= 0 {
+			e.recompute(c, e.driver.CreateUnsafeTx())
+			continue
+		}
+		e.mu.Lock()
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		c.timer = time.AfterFunc(c.spec.Debounce, func() {
+			if !e.isRegistered(c) {
+				return
+			}
+			e.driver.AccessAsync(func(tx DriverTx) error {
+				e.recompute(c, tx)
+				return nil
+			})
+		})
+		e.mu.Unlock()
+	}
+}
+
+// isRegistered checks whether computed control is still registered in engine
+func (e *ComputeEngine) isRegistered(c *computedControl) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.computed[c.pair] == c
+}
+
+// recompute calculates and publishes computed control value, must be run in driver loop.
+// Calculation errors are published to control's meta/error as read error flag (see ToMetaError)
+func (e *ComputeEngine) recompute(c *computedControl, tx DriverTx) {
+	if c.control.IsDeleted() || !e.isRegistered(c) {
+		return
+	}
+	c.control.SetTx(tx)
+	value, err := e.calculate(c, tx)
+	if err != nil {
+		Debug.Printf("computed control %s/%s: %v", c.pair.deviceID, c.pair.controlID, err)
+		c.failed = true
+		e.logFailure(c, "set error", c.control.SetError(ToMetaError(err))())
+		return
+	}
+	if c.failed {
+		c.failed = false
+		e.logFailure(c, "clear error", c.control.SetError(nil)())
+	}
+	e.logFailure(c, "update value", c.control.UpdateValue(value, true)())
+}
+
+func (e *ComputeEngine) logFailure(c *computedControl, action string, err error) {
+	if err != nil {
+		Error.Printf("computed control %s/%s: failed to %s: %v", c.pair.deviceID, c.pair.controlID, action, err)
+	}
+}
+
+func (e *ComputeEngine) calculate(c *computedControl, tx DriverTx) (any, error) {
+	values := make(map[string]any, len(c.spec.Sources))
+	for name, src := range c.spec.Sources {
+		dev := tx.GetDevice(src.deviceID)
+		if dev == nil {
+			return nil, fmt.Errorf("%w: %s", DeviceNotExistError, src.deviceID)
+		}
+		ctrl := dev.GetControl(src.controlID)
+		if ctrl == nil {
+			return nil, fmt.Errorf("%w: %s/%s", NoSuchControlError, src.deviceID, src.controlID)
+		}
+		value, err := ctrl.GetValue()
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", src.deviceID, src.controlID, err)
+		}
+		values[name] = value
+	}
+	if c.expr != nil {
+		return c.expr.Eval(values)
+	}
+	return c.spec.Func(values)
+}
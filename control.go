@@ -1,6 +1,7 @@
 package wbgong
 
 // ControlError is MQTT control error interface
+// Use MetaError for conventional error flags
 type ControlError interface {
 	Error() string
 }
@@ -27,6 +28,14 @@ type ControlArgs interface {
 	// If true - control will not create topic in mqtt before once explicitly set value to this control
 	// It also means that storage will be not used to store or restore values at all
 	SetLazyInit(bool) ControlArgs
+	// SetComputed makes control computed: its value is recalculated
+	// by driver's ComputeEngine whenever one of its sources changes
+	SetComputed(*ComputedValue) ControlArgs
+	// SetPublishPolicy sets deadband, minimal interval and heartbeat for value publishing
+	SetPublishPolicy(PublishPolicy) ControlArgs
+	// SetExtraMeta sets custom (vendor-specific) meta key published in meta v2 JSON,
+	// see ValidateExtraMetaKey
+	SetExtraMeta(key string, value any) ControlArgs
 
 	GetDevice() Device
 	GetID() *string
@@ -45,6 +54,9 @@ type ControlArgs interface {
 	GetValue() any
 	GetDoLoadPrevious() *bool
 	GetLazyInit() *bool
+	GetComputed() *ComputedValue
+	GetPublishPolicy() *PublishPolicy
+	GetExtraMeta() MetaInfo
 }
 
 // Control is a user representation of MQTT device control
@@ -87,6 +99,8 @@ type Control interface {
 	GetValue() (any, error)          // Gets control value (converted according to type)
 	GetRawValue() string             // Gets control value string
 	GetLazyInit() bool               // Gets control lazyInit flag
+	GetPublishPolicy() PublishPolicy // Gets control value publish policy
+	GetExtraMeta() MetaInfo          // Gets custom meta keys
 
 	// generic setters
 	SetDescription(desc string) FuncError
@@ -104,6 +118,13 @@ type Control interface {
 	// If true - control will not create topic in mqtt before once explicitly set value to this control
 	// It also means that storage will be not used to store or restore values at all
 	SetLazyInit(bool) FuncError
+	// SetPublishPolicy sets value publish policy for local control.
+	// Values suppressed by policy are stored in control but not published,
+	// see PublishGate
+	SetPublishPolicy(p PublishPolicy) FuncError
+	// SetExtraMeta sets custom meta key and republishes meta v2 JSON.
+	// Nil value removes key
+	SetExtraMeta(key string, value any) FuncError
 
 	// Updates control value for local device
 	// and notifies subscribers if flag is set
@@ -127,6 +148,13 @@ type Control interface {
 	// Sets new 'on' value handler (for local controls only)
 	SetOnValueReceiveHandler(f ControlValueHandler) error
 
+	// Adds '/on' value interceptor (for local controls only).
+	// Control interceptors run after driver-wide ones, see Driver.AddOnValueInterceptor
+	AddOnValueInterceptor(f OnValueInterceptor) (HandlerID, error)
+
+	// Removes '/on' value interceptor
+	RemoveOnValueInterceptor(id HandlerID)
+
 	// Marks control as deleted
 	// Used by LocalDevice in RemoveControl
 	MarkDeleted()
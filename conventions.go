@@ -60,20 +60,21 @@ const (
 	//
 	// Meta information subtopics
 
-	CONV_META_SUBTOPIC_DRIVER        = "driver"      // for /devices/+/meta/driver
-	CONV_META_SUBTOPIC_TITLE         = "name"        // for /devices/+/meta/name ('name' is legacy)
-	CONV_META_SUBTOPIC_TITLE_V2      = "title"       // for /devices/+/meta/title
-	CONV_META_SUBTOPIC_ERROR         = "error"       // for /devices/+/controls/+/meta/error and /devices/+/meta/error
-	CONV_META_SUBTOPIC_ORDER         = "order"       // for /devices/+/controls/+/meta/order
-	CONV_META_SUBTOPIC_TYPE          = "type"        // for /devices/+/controls/+/meta/type
-	CONV_META_SUBTOPIC_UNITS         = "units"       // for /devices/+/controls/+/meta/units
-	CONV_META_SUBTOPIC_MAX           = "max"         // for /devices/+/controls/+/meta/max
-	CONV_META_SUBTOPIC_MIN           = "min"         // for /devices/+/controls/+/meta/min
-	CONV_META_SUBTOPIC_PRECISION     = "precision"   // for /devices/+/controls/+/meta/precision
-	CONV_META_SUBTOPIC_DESCRIPTION   = "description" // for /devices/+/controls/+/meta/description
-	CONV_META_SUBTOPIC_CONTROL_TITLE = "title"       // for /devices/+/controls/+/meta/title
-	CONV_META_SUBTOPIC_READONLY      = "readonly"    // for /devices/+/controls/+/meta/readonly
-	CONV_META_SUBTOPIC_CONTROL_ENUM  = "enum"        // for /devices/+/controls/+/meta/enum
+	CONV_META_SUBTOPIC_DRIVER        = "driver"       // for /devices/+/meta/driver
+	CONV_META_SUBTOPIC_TITLE         = "name"         // for /devices/+/meta/name ('name' is legacy)
+	CONV_META_SUBTOPIC_TITLE_V2      = "title"        // for /devices/+/meta/title
+	CONV_META_SUBTOPIC_ERROR         = "error"        // for /devices/+/controls/+/meta/error and /devices/+/meta/error
+	CONV_META_SUBTOPIC_ORDER         = "order"        // for /devices/+/controls/+/meta/order
+	CONV_META_SUBTOPIC_TYPE          = "type"         // for /devices/+/controls/+/meta/type
+	CONV_META_SUBTOPIC_UNITS         = "units"        // for /devices/+/controls/+/meta/units
+	CONV_META_SUBTOPIC_MAX           = "max"          // for /devices/+/controls/+/meta/max
+	CONV_META_SUBTOPIC_MIN           = "min"          // for /devices/+/controls/+/meta/min
+	CONV_META_SUBTOPIC_PRECISION     = "precision"    // for /devices/+/controls/+/meta/precision
+	CONV_META_SUBTOPIC_DESCRIPTION   = "description"  // for /devices/+/controls/+/meta/description
+	CONV_META_SUBTOPIC_CONTROL_TITLE = "title"        // for /devices/+/controls/+/meta/title
+	CONV_META_SUBTOPIC_READONLY      = "readonly"     // for /devices/+/controls/+/meta/readonly
+	CONV_META_SUBTOPIC_CONTROL_ENUM  = "enum"         // for /devices/+/controls/+/meta/enum
+	CONV_META_SUBTOPIC_AVAILABILITY  = "availability" // for /devices/+/meta/availability
 
 	// Type names
 	CONV_TYPE_SWITCH     = "switch"
@@ -120,6 +121,9 @@ const (
 	CONV_SWITCH_DEFAULT_VALUE = CONV_SWITCH_VALUE_FALSE
 	CONV_ALARM_DEFAULT_VALUE  = CONV_ALARM_VALUE_FALSE
 
+	CONV_AVAILABILITY_ONLINE  = "online"
+	CONV_AVAILABILITY_OFFLINE = "offline"
+
 	CONV_SUBTOPIC_ALL = "+"
 )
 
@@ -133,6 +137,26 @@ const (
 	CONV_DATATYPE_BUTTON
 )
 
+// TypeToDataType returns data type used to represent values of given control type.
+// CONV_DEFAULT_DATATYPE is returned for unknown types
+func TypeToDataType(typestr string) ControlDataType {
+	switch typestr {
+	case CONV_TYPE_SWITCH, CONV_TYPE_ALARM:
+		return CONV_DATATYPE_BOOLEAN
+	case CONV_TYPE_PUSHBUTTON:
+		return CONV_DATATYPE_BUTTON
+	case CONV_TYPE_RANGE, CONV_TYPE_VALUE,
+		CONV_TYPE_TEMPERATURE, CONV_TYPE_REL_HUMIDITY, CONV_TYPE_ATMOSPHERIC_PRESSURE,
+		CONV_TYPE_RAINFALL, CONV_TYPE_WIND_SPEED, CONV_TYPE_POWER, CONV_TYPE_POWER_CONSUMPTION,
+		CONV_TYPE_VOLTAGE, CONV_TYPE_WATER_FLOW, CONV_TYPE_WATER_CONSUMPTION, CONV_TYPE_RESISTANCE,
+		CONV_TYPE_CONCENTRATION, CONV_TYPE_PRESSURE, CONV_TYPE_ILLUMINANCE, CONV_TYPE_SOUND_LEVEL,
+		CONV_TYPE_HEAT_POWER, CONV_TYPE_HEAT_ENERGY, CONV_TYPE_CURRENT:
+		return CONV_DATATYPE_FLOAT
+	default:
+		return CONV_DEFAULT_DATATYPE
+	}
+}
+
 var (
 	funcToTypedValue        func(string, string) (any, error)
 	funcRawValueToDataTyped func(string, ControlDataType) (any, error)
@@ -4,6 +4,15 @@
 
 package wbgong
 
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
 // DeviceFilter is a device filter representation for Driver
 type DeviceFilter interface {
 	// Topics() returns list of deviceId/controlId pairs to subscribe to
@@ -20,6 +29,11 @@ type DeviceControlPair struct {
 	controlID string
 }
 
+// NewDeviceControlPair returns pair for given device and control IDs
+func NewDeviceControlPair(deviceID, controlID string) DeviceControlPair {
+	return DeviceControlPair{deviceID, controlID}
+}
+
 func (dcp *DeviceControlPair) GetDeviceID() string {
 	return dcp.deviceID
 }
@@ -68,8 +82,8 @@ func (f *DeviceListFilter) Topics() []DeviceControlPair {
 }
 
 func (f *DeviceListFilter) MatchTopic(t string) bool {
-	// we presume that list of topics was used as is, so all topics from list are allowed
-	return true
+	topic, ok := parseDevicesTopic(t)
+	return ok && f.devices[topic.device]
 }
 
 func NewDeviceListFilter(devices ...string) *DeviceListFilter {
@@ -83,3 +97,353 @@ func NewDeviceListFilter(devices ...string) *DeviceListFilter {
 
 	return d
 }
+
+// ControlListFilter is a filter which allows only given list of device/control pairs.
+// Device meta topics of listed devices are allowed too
+type ControlListFilter struct {
+	pairs   map[DeviceControlPair]bool
+	devices map[string]bool
+}
+
+func NewControlListFilter(pairs ...DeviceControlPair) *ControlListFilter {
+	f := &ControlListFilter{
+		pairs:   make(map[DeviceControlPair]bool),
+		devices: make(map[string]bool),
+	}
+	for _, p := range pairs {
+		f.pairs[p] = true
+		f.devices[p.deviceID] = true
+	}
+	return f
+}
+
+func (f *ControlListFilter) Topics() []DeviceControlPair {
+	r := make([]DeviceControlPair, 0, len(f.pairs))
+	for p := range f.pairs {
+		r = append(r, p)
+	}
+	return minimizePairs(r)
+}
+
+func (f *ControlListFilter) MatchTopic(t string) bool {
+	topic, ok := parseDevicesTopic(t)
+	if !ok {
+		return false
+	}
+	if topic.control == "" {
+		return f.devices[topic.device]
+	}
+	return f.pairs[DeviceControlPair{topic.device, topic.control}]
+}
+
+// PatternFilter is a filter which allows devices and controls with IDs
+// matching glob or regular expression patterns
+type PatternFilter struct {
+	device       string
+	control      string
+	matchDevice  func(id string) bool
+	matchControl func(id string) bool
+}
+
+func isGlobLiteral(pattern string) bool {
+	return !strings.ContainsAny(pattern, `*?[\`)
+}
+
+// subscriptionLevel returns literal ID as MQTT topic level
+// or '+' if it can't be used in subscription (empty or has MQTT special characters)
+func subscriptionLevel(literal string) string {
+	if literal == "" || strings.ContainsAny(literal, "+#/") {
+		return CONV_SUBTOPIC_ALL
+	}
+	return literal
+}
+
+func globMatcher(pattern string) (func(string) bool, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("%w: %s", IncorrectPatternError, pattern)
+	}
+	return func(id string) bool {
+		ok, _ := path.Match(pattern, id)
+		return ok
+	}, nil
+}
+
+// NewGlobFilter creates filter with glob patterns (see path.Match) for device and control IDs.
+// Empty control pattern allows all controls
+func NewGlobFilter(device, control string) (*PatternFilter, error) {
+	if control == "" {
+		control = "*"
+	}
+	f := &PatternFilter{device: CONV_SUBTOPIC_ALL, control: CONV_SUBTOPIC_ALL}
+	var err error
+	if f.matchDevice, err = globMatcher(device); err != nil {
+		return nil, err
+	}
+	if f.matchControl, err = globMatcher(control); err != nil {
+		return nil, err
+	}
+	if isGlobLiteral(device) {
+		f.device = subscriptionLevel(device)
+	}
+	if isGlobLiteral(control) {
+		f.control = subscriptionLevel(control)
+	}
+	return f, nil
+}
+
+func regexpMatcher(expr string) (func(string) bool, string, error) {
+	inner, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", IncorrectPatternError, err)
+	}
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", IncorrectPatternError, err)
+	}
+	topic := CONV_SUBTOPIC_ALL
+	if literal, complete := inner.LiteralPrefix(); complete {
+		topic = subscriptionLevel(literal)
+	}
+	return re.MatchString, topic, nil
+}
+
+// NewRegexpFilter creates filter with regular expressions for whole device and control IDs.
+// Empty control expression allows all controls
+func NewRegexpFilter(device, control string) (*PatternFilter, error) {
+	if control == "" {
+		control = ".*"
+	}
+	f := &PatternFilter{}
+	var err error
+	if f.matchDevice, f.device, err = regexpMatcher(device); err != nil {
+		return nil, err
+	}
+	if f.matchControl, f.control, err = regexpMatcher(control); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *PatternFilter) Topics() []DeviceControlPair {
+	return []DeviceControlPair{{f.device, f.control}}
+}
+
+func (f *PatternFilter) MatchTopic(t string) bool {
+	topic, ok := parseDevicesTopic(t)
+	if !ok || !f.matchDevice(topic.device) {
+		return false
+	}
+	return topic.control == "" || f.matchControl(topic.control)
+}
+
+// AndFilter allows topics allowed by all filters
+type AndFilter struct {
+	filters []DeviceFilter
+}
+
+func NewAndFilter(filters ...DeviceFilter) *AndFilter {
+	return &AndFilter{filters}
+}
+
+func (f *AndFilter) Topics() []DeviceControlPair {
+	if len(f.filters) == 0 {
+		return []DeviceControlPair{}
+	}
+	r := f.filters[0].Topics()
+	for _, filter := range f.filters[1:] {
+		var next []DeviceControlPair
+		for _, a := range r {
+			for _, b := range filter.Topics() {
+				if p, ok := intersectPairs(a, b); ok {
+					next = append(next, p)
+				}
+			}
+		}
+		r = minimizePairs(next)
+	}
+	return minimizePairs(r)
+}
+
+func (f *AndFilter) MatchTopic(t string) bool {
+	for _, filter := range f.filters {
+		if !filter.MatchTopic(t) {
+			return false
+		}
+	}
+	return len(f.filters) > 0
+}
+
+// OrFilter allows topics allowed by any of filters
+type OrFilter struct {
+	filters []DeviceFilter
+}
+
+func NewOrFilter(filters ...DeviceFilter) *OrFilter {
+	return &OrFilter{filters}
+}
+
+func (f *OrFilter) Topics() []DeviceControlPair {
+	var r []DeviceControlPair
+	for _, filter := range f.filters {
+		r = append(r, filter.Topics()...)
+	}
+	return minimizePairs(r)
+}
+
+func (f *OrFilter) MatchTopic(t string) bool {
+	for _, filter := range f.filters {
+		if filter.MatchTopic(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NotFilter allows devices topics denied by given filter.
+// It subscribes to all devices, so use it within AndFilter to limit subscriptions
+type NotFilter struct {
+	filter DeviceFilter
+}
+
+func NewNotFilter(filter DeviceFilter) *NotFilter {
+	return &NotFilter{filter}
+}
+
+func (f *NotFilter) Topics() []DeviceControlPair {
+	return []DeviceControlPair{{CONV_SUBTOPIC_ALL, CONV_SUBTOPIC_ALL}}
+}
+
+func (f *NotFilter) MatchTopic(t string) bool {
+	if _, ok := parseDevicesTopic(t); !ok {
+		return false
+	}
+	return !f.filter.MatchTopic(t)
+}
+
+func pairPartCovers(a, b string) bool {
+	return a == CONV_SUBTOPIC_ALL || a == b
+}
+
+// pairCovers checks whether subscription a includes subscription b
+func pairCovers(a, b DeviceControlPair) bool {
+	return pairPartCovers(a.deviceID, b.deviceID) && pairPartCovers(a.controlID, b.controlID)
+}
+
+func intersectPairPart(a, b string) (string, bool) {
+	switch {
+	case a == CONV_SUBTOPIC_ALL:
+		return b, true
+	case b == CONV_SUBTOPIC_ALL || a == b:
+		return a, true
+	}
+	return "", false
+}
+
+func intersectPairs(a, b DeviceControlPair) (p DeviceControlPair, ok bool) {
+	if p.deviceID, ok = intersectPairPart(a.deviceID, b.deviceID); !ok {
+		return
+	}
+	p.controlID, ok = intersectPairPart(a.controlID, b.controlID)
+	return
+}
+
+// minimizePairs removes duplicates and pairs covered by other pairs
+func minimizePairs(pairs []DeviceControlPair) []DeviceControlPair {
+	r := make([]DeviceControlPair, 0, len(pairs))
+	for i, p := range pairs {
+		covered := false
+		for j, q := range pairs {
+			// equal pairs: keep the first one only
+			if i != j && pairCovers(q, p) && (q != p || j < i) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			r = append(r, p)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].deviceID != r[j].deviceID {
+			return r[i].deviceID < r[j].deviceID
+		}
+		return r[i].controlID < r[j].controlID
+	})
+	return r
+}
+
+// FilterSet is a union of filters which can be changed incrementally
+// (see Driver.AddToFilter). Filters are compared by identity,
+// so use pointers to filters; filters of non-comparable types are compared deeply
+type FilterSet struct {
+	filters []DeviceFilter
+}
+
+// NewFilterSet creates set with copy of given filters list
+func NewFilterSet(filters ...DeviceFilter) *FilterSet {
+	return &FilterSet{append([]DeviceFilter(nil), filters...)}
+}
+
+func sameFilter(a, b DeviceFilter) bool {
+	ta := reflect.TypeOf(a)
+	if ta != reflect.TypeOf(b) {
+		return false
+	}
+	if ta == nil || ta.Comparable() {
+		return a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Add adds filter to set, returns false if it's already there
+func (s *FilterSet) Add(f DeviceFilter) bool {
+	for _, filter := range s.filters {
+		if sameFilter(filter, f) {
+			return false
+		}
+	}
+	s.filters = append(s.filters, f)
+	return true
+}
+
+// Remove removes filter from set, returns false if there is no such filter
+func (s *FilterSet) Remove(f DeviceFilter) bool {
+	for i, filter := range s.filters {
+		if sameFilter(filter, f) {
+			s.filters = append(s.filters[:i:i], s.filters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Filters returns copy of filters list
+func (s *FilterSet) Filters() []DeviceFilter {
+	return append([]DeviceFilter(nil), s.filters...)
+}
+
+func (s *FilterSet) Topics() []DeviceControlPair {
+	return (&OrFilter{s.filters}).Topics()
+}
+
+func (s *FilterSet) MatchTopic(t string) bool {
+	return (&OrFilter{s.filters}).MatchTopic(t)
+}
+
+// FilterMatchesDevice checks whether filter allows device meta or any of its controls
+func FilterMatchesDevice(f DeviceFilter, dev Device) bool {
+	if f.MatchTopic(fmt.Sprintf(CONV_DEVICE_META_V2_FMT, dev.GetId())) {
+		return true
+	}
+	for _, ctrl := range dev.ControlsList() {
+		if FilterMatchesControl(f, ctrl) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterMatchesControl checks whether filter allows control
+func FilterMatchesControl(f DeviceFilter, ctrl Control) bool {
+	return f.MatchTopic(fmt.Sprintf(CONV_CONTROL_VALUE_FMT, ctrl.GetDevice().GetId(), ctrl.GetId()))
+}
@@ -0,0 +1,126 @@
+package wbgong
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustFilter(t *testing.T, f DeviceFilter, err error) DeviceFilter {
+	require.NoError(t, err)
+	return f
+}
+
+func TestDeviceFilterTopics(t *testing.T) {
+	pair := NewDeviceControlPair
+	glob := func(dev, ctrl string) DeviceFilter {
+		f, err := NewGlobFilter(dev, ctrl)
+		return mustFilter(t, f, err)
+	}
+	re := func(dev, ctrl string) DeviceFilter {
+		f, err := NewRegexpFilter(dev, ctrl)
+		return mustFilter(t, f, err)
+	}
+	tests := []struct {
+		name   string
+		filter DeviceFilter
+		topics []DeviceControlPair
+	}{
+		{"all", &AllDevicesFilter{}, []DeviceControlPair{pair("+", "+")}},
+		{"none", &NoDevicesFilter{}, []DeviceControlPair{}},
+		{"control list", NewControlListFilter(pair("a", "x"), pair("a", "+"), pair("b", "y")),
+			[]DeviceControlPair{pair("a", "+"), pair("b", "y")}},
+		{"glob literal", glob("wb-gpio", "K1"), []DeviceControlPair{pair("wb-gpio", "K1")}},
+		{"glob wildcard", glob("wb-*", ""), []DeviceControlPair{pair("+", "+")}},
+		{"glob mqtt wildcard", glob("a+b", "c#"), []DeviceControlPair{pair("+", "+")}},
+		{"regexp literal", re("wb-gpio", "K1"), []DeviceControlPair{pair("wb-gpio", "K1")}},
+		{"regexp wildcard", re("wb-.*", "K[0-9]"), []DeviceControlPair{pair("+", "+")}},
+		{"regexp escaped plus", re(`a\+b`, "x/y"), []DeviceControlPair{pair("+", "+")}},
+		{"and", NewAndFilter(&AllDevicesFilter{}, NewDeviceListFilter("a")),
+			[]DeviceControlPair{pair("a", "+")}},
+		{"or", NewOrFilter(NewDeviceListFilter("a"), NewControlListFilter(pair("a", "x"), pair("b", "y"))),
+			[]DeviceControlPair{pair("a", "+"), pair("b", "y")}},
+		{"not", NewNotFilter(NewDeviceListFilter("a")), []DeviceControlPair{pair("+", "+")}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.topics, tc.filter.Topics())
+		})
+	}
+}
+
+func TestDeviceFilterMatchTopic(t *testing.T) {
+	glob, err := NewGlobFilter("wb-*", "K?")
+	require.NoError(t, err)
+	re, err := NewRegexpFilter("dev[0-9]+", "")
+	require.NoError(t, err)
+	list := NewControlListFilter(NewDeviceControlPair("a", "x"))
+	tests := []struct {
+		filter DeviceFilter
+		topic  string
+		match  bool
+	}{
+		{glob, "/devices/wb-gpio/controls/K1", true},
+		{glob, "/devices/wb-gpio/controls/K1/meta", true},
+		{glob, "/devices/wb-gpio/meta/name", true},
+		{glob, "/devices/wb-gpio/controls/K10", false},
+		{glob, "/devices/gpio/controls/K1", false},
+		{re, "/devices/dev12/controls/anything/meta/type", true},
+		{re, "/devices/dev/controls/x", false},
+		{re, "/devices/xdev1/controls/x", false},
+		{list, "/devices/a/controls/x", true},
+		{list, "/devices/a/meta", true},
+		{list, "/devices/a/controls/y", false},
+		{NewAndFilter(glob, NewDeviceListFilter("wb-gpio")), "/devices/wb-gpio/controls/K2", true},
+		{NewAndFilter(glob, NewDeviceListFilter("wb-gpio")), "/devices/wb-adc/controls/K2", false},
+		{NewAndFilter(), "/devices/a/controls/x", false},
+		{NewOrFilter(glob, list), "/devices/a/controls/x", true},
+		{NewNotFilter(list), "/devices/a/controls/y", true},
+		{NewNotFilter(list), "/devices/a/controls/x", false},
+		{NewNotFilter(list), "/other/topic", false},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.match, tc.filter.MatchTopic(tc.topic), tc.topic)
+	}
+}
+
+func TestDeviceFilterIncorrectPattern(t *testing.T) {
+	_, err := NewRegexpFilter(")(", "")
+	assert.ErrorIs(t, err, IncorrectPatternError)
+	_, err = NewRegexpFilter("dev", "[")
+	assert.ErrorIs(t, err, IncorrectPatternError)
+	_, err = NewGlobFilter("[", "")
+	assert.ErrorIs(t, err, IncorrectPatternError)
+}
+
+// valueFilter is a non-comparable filter passed by value
+type valueFilter struct {
+	devices []string
+}
+
+func (f valueFilter) Topics() []DeviceControlPair {
+	return []DeviceControlPair{}
+}
+
+func (f valueFilter) MatchTopic(t string) bool {
+	return false
+}
+
+func TestFilterSet(t *testing.T) {
+	a, b := NewDeviceListFilter("a"), NewDeviceListFilter("b")
+	filters := []DeviceFilter{a, b}
+	s := NewFilterSet(filters...)
+
+	assert.False(t, s.Add(a))
+	assert.True(t, s.Remove(a))
+	assert.False(t, s.Remove(a))
+	// caller's slice is not modified
+	assert.Equal(t, []DeviceFilter{a, b}, filters)
+	assert.Equal(t, []DeviceControlPair{NewDeviceControlPair("b", "+")}, s.Topics())
+
+	// non-comparable filters don't panic
+	assert.True(t, s.Add(valueFilter{[]string{"x"}}))
+	assert.False(t, s.Add(valueFilter{[]string{"x"}}))
+	assert.True(t, s.Remove(valueFilter{[]string{"x"}}))
+}
@@ -1,8 +1,10 @@
 package wbgong
 
 import (
+	"encoding/json"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -46,12 +48,19 @@ type Device interface {
 	// Gets all device metadata for /meta
 	GetMetaJson() MetaInfo
 
+	// Gets custom device meta keys
+	GetExtraMeta() MetaInfo
+
 	// Marks device as deleted
 	// Used by Driver frontend and device itself
 	MarkDeleted()
 
 	// Checks if device is marked as deleted
 	IsDeleted() bool
+
+	// Gets device availability (/meta/availability).
+	// For external devices it also accounts availability of owning driver
+	GetAvailability() DeviceAvailability
 }
 
 // LocalDevice is a user representation of local MQTT device
@@ -76,6 +85,20 @@ type LocalDevice interface {
 	RemoveControl(id string) func() error
 
 	SetError(err DeviceError) FuncError
+
+	// Sets device availability and publishes it in meta.
+	// Read error (see AvailabilityControlError, SetLocalDeviceAvailability) is set on all device controls
+	// while device is offline
+	SetAvailability(a DeviceAvailability) FuncError
+
+	// Applies all value and meta changes from batch as one unit
+	// and notifies subscribers with ControlBatchEvent and per-control ControlValueEvents
+	// (see ControlBatch.Events) if flag is set
+	ApplyBatch(b *ControlBatch, notifySubs bool) FuncError
+
+	// Sets custom meta key and republishes meta v2 JSON.
+	// Nil value removes key
+	SetExtraMeta(key string, value any) FuncError
 }
 
 // ExternalDevice is a user representation of external MQTT device
@@ -92,10 +115,15 @@ type ExternalDevice interface {
 	// Sets device title
 	SetTitle(title Title)
 
+	// Sets effective device availability, used by driver on DeviceAvailabilityEvent
+	SetAvailability(a DeviceAvailability)
+
 	// Internal function to remove control on cleanup
 	RemoveControl(id string)
 }
 
+// DeviceError is MQTT device error interface
+// Use MetaError for conventional error flags
 type DeviceError interface {
 	Error() string
 }
@@ -108,11 +136,15 @@ type LocalDeviceArgs interface {
 	SetTitle(v Title) LocalDeviceArgs
 	SetDriver(v DeviceDriver) LocalDeviceArgs
 	SetError(v DeviceError) LocalDeviceArgs
+	// SetExtraMeta sets custom (vendor-specific) meta key published in meta v2 JSON,
+	// see ValidateExtraMetaKey
+	SetExtraMeta(key string, value any) LocalDeviceArgs
 	GetID() *string
 	GetTitle() *Title
 	GetDriver() DeviceDriver
 	GetVirtual() bool
 	GetDoLoadPrevious() bool
+	GetExtraMeta() MetaInfo
 }
 
 // MetaInfo is a type that represents /meta/+ topics for drivers and controls
@@ -150,12 +182,14 @@ func (m MetaInfo) String() string {
 // newMeta: [ "a": "123", "b": "xyz", "d": "0" ]
 // oldMeta: [ "a": "456", "c": "hello", "d": "0" ]
 // delta: [ "a": "123", "b": "xyz", "c": "" ]
+//
+// Values are compared deeply, so titles and enums are compared by content
 func (m MetaInfo) Delta(oldMeta MetaInfo) (delta MetaInfo) {
 	delta = make(MetaInfo)
 
 	// find changed values
 	for key, newValue := range m {
-		if oldValue, ok := oldMeta[key]; !ok || (ok && oldValue != newValue) {
+		if oldValue, ok := oldMeta[key]; !ok || !metaValuesEqual(oldValue, newValue) {
 			delta[key] = newValue
 		}
 	}
@@ -169,3 +203,81 @@ func (m MetaInfo) Delta(oldMeta MetaInfo) (delta MetaInfo) {
 
 	return
 }
+
+// metaTitle converts title meta value (Title, JSON object or plain english string) to Title
+func metaTitle(v any) (Title, error) {
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case Title:
+		return t, nil
+	case map[string]string:
+		return Title(t), nil
+	case string:
+		return Title{"en": t}, nil
+	case map[string]any:
+		title := make(Title, len(t))
+		for lang, s := range t {
+			str, ok := s.(string)
+			if !ok {
+				return nil, WrongValueTypeError
+			}
+			title[lang] = str
+		}
+		return title, nil
+	default:
+		return nil, WrongValueTypeError
+	}
+}
+
+// metaEnum converts enum titles meta value to map of Titles
+func metaEnum(v any) (map[string]Title, error) {
+	switch e := v.(type) {
+	case map[string]Title:
+		return e, nil
+	case map[string]any:
+		enum := make(map[string]Title, len(e))
+		for key, t := range e {
+			title, err := metaTitle(t)
+			if err != nil {
+				return nil, err
+			}
+			enum[key] = title
+		}
+		return enum, nil
+	default:
+		return nil, WrongValueTypeError
+	}
+}
+
+// metaFloat converts numeric meta value, numeric strings (legacy meta) are accepted
+func metaFloat(v any) (float64, error) {
+	switch f := v.(type) {
+	case float64:
+		return f, nil
+	case float32:
+		return float64(f), nil
+	case int:
+		return float64(f), nil
+	case int64:
+		return float64(f), nil
+	case json.Number:
+		return f.Float64()
+	case string:
+		return strconv.ParseFloat(f, 64)
+	default:
+		return 0, WrongValueTypeError
+	}
+}
+
+// metaBool converts boolean meta value, "1"/"true" strings (legacy meta) are accepted
+func metaBool(v any) (bool, error) {
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		return b == CONV_META_BOOL_TRUE || b == "true", nil
+	default:
+		return false, WrongValueTypeError
+	}
+}
@@ -0,0 +1,601 @@
+// Device templates allow to describe local devices declaratively
+// in JSON or YAML files (JSON is a subset of YAML, so single parser is used).
+//
+// Template example:
+//
+//	params:
+//	  name: kitchen
+//	  channels: 4
+//	  serial: "0001"
+//	devices:
+//	  - id: relays_${name}
+//	    title: {en: "Relays (${name})", ru: "Реле (${name})"}
+//	    virtual: true
+//	    load_previous: true
+//	    meta:
+//	      serial: "${serial}"
+//	    controls:
+//	      - id: K${n}
+//	        repeat: ${channels}
+//	        type: switch
+//	        readonly: false
+//	        title: Relay ${n}
+//	        order: ${n}
+//	        value: "0"
+//
+// ${param} placeholders are replaced with parameter values in all string fields.
+// 'meta' sections contain custom meta keys (see ValidateExtraMetaKey).
+// Controls with 'repeat' are created several times, ${n} (starting from 1)
+// and ${i} (starting from 0) contain current copy number.
+
+package wbgong
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	templateParamRegexp     = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	templateYamlErrorRegexp = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+)
+
+// known template keys, used for schema validation
+var (
+	templateRootKeys    = []string{"params", "devices"}
+	templateDeviceKeys  = []string{"id", "title", "virtual", "load_previous", "meta", "controls"}
+	templateControlKeys = []string{
+		"id", "repeat", "type", "title", "description", "units", "readonly",
+		"min", "max", "precision", "order", "enum", "value", "load_previous", "lazy_init", "meta",
+	}
+)
+
+// TemplateError is an error in device template with position information
+type TemplateError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+// ControlSpec is a control description rendered from template
+type ControlSpec struct {
+	Id           string
+	Type         string
+	Title        Title
+	Description  *string
+	Units        *string
+	Readonly     *bool
+	Min          *float64
+	Max          *float64
+	Precision    *float64
+	Order        *int
+	EnumTitles   map[string]Title
+	Value        *string
+	LoadPrevious *bool
+	LazyInit     *bool
+	ExtraMeta    MetaInfo
+}
+
+// DeviceSpec is a local device description rendered from template
+type DeviceSpec struct {
+	Id           string
+	Title        Title
+	Virtual      bool
+	LoadPrevious bool
+	ExtraMeta    MetaInfo
+	Controls     []ControlSpec
+}
+
+// DeviceTemplate is a parsed device template
+type DeviceTemplate struct {
+	file     string
+	root     *yaml.Node
+	defaults map[string]string
+}
+
+// LoadDeviceTemplate reads and parses device template file
+func LoadDeviceTemplate(path string) (*DeviceTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+	return ParseDeviceTemplate(path, data)
+}
+
+// ParseDeviceTemplate parses device template from data.
+// File name is used in error messages only
+func ParseDeviceTemplate(file string, data []byte) (*DeviceTemplate, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		templateErr := &TemplateError{File: file, Line: 1, Column: 1, Msg: err.Error()}
+		if m := templateYamlErrorRegexp.FindStringSubmatch(err.Error()); m != nil {
+			templateErr.Line, _ = strconv.Atoi(m[1])
+			templateErr.Msg = m[2]
+		}
+		return nil, templateErr
+	}
+	t := &DeviceTemplate{
+		file:     file,
+		defaults: make(map[string]string),
+	}
+	if len(doc.Content) == 0 {
+		return nil, &TemplateError{File: file, Line: 1, Column: 1, Msg: "empty template"}
+	}
+	t.root = doc.Content[0]
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *DeviceTemplate) errorf(n *yaml.Node, format string, args ...any) error {
+	return &TemplateError{
+		File:   t.file,
+		Line:   n.Line,
+		Column: n.Column,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+// validate checks template structure, values are checked on Render
+func (t *DeviceTemplate) validate() error {
+	if err := t.checkMapping(t.root, templateRootKeys); err != nil {
+		return err
+	}
+	if params := mappingValue(t.root, "params"); params != nil {
+		if err := t.checkMapping(params, nil); err != nil {
+			return err
+		}
+		for i := 0; i < len(params.Content); i += 2 {
+			value := params.Content[i+1]
+			if value.Kind != yaml.ScalarNode {
+				return t.errorf(value, "parameter '%s' must be a scalar", params.Content[i].Value)
+			}
+			t.defaults[params.Content[i].Value] = value.Value
+		}
+	}
+	devices := mappingValue(t.root, "devices")
+	if devices == nil {
+		return t.errorf(t.root, "no devices defined")
+	}
+	if devices.Kind != yaml.SequenceNode {
+		return t.errorf(devices, "devices must be a list")
+	}
+	for _, dev := range devices.Content {
+		if err := t.checkMapping(dev, templateDeviceKeys); err != nil {
+			return err
+		}
+		if mappingValue(dev, "id") == nil {
+			return t.errorf(dev, "device id is missing")
+		}
+		controls := mappingValue(dev, "controls")
+		if controls == nil {
+			continue
+		}
+		if controls.Kind != yaml.SequenceNode {
+			return t.errorf(controls, "controls must be a list")
+		}
+		for _, ctrl := range controls.Content {
+			if err := t.checkMapping(ctrl, templateControlKeys); err != nil {
+				return err
+			}
+			// readonly is mandatory for controls (see ReadonlyMissingError)
+			for _, key := range []string{"id", "type", "readonly"} {
+				if mappingValue(ctrl, key) == nil {
+					return t.errorf(ctrl, "control %s is missing", key)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkMapping checks that node is a mapping with known keys only (any keys if known is nil)
+func (t *DeviceTemplate) checkMapping(n *yaml.Node, known []string) error {
+	if n.Kind != yaml.MappingNode {
+		return t.errorf(n, "object expected")
+	}
+	for i := 0; i < len(n.Content); i += 2 {
+		key := n.Content[i]
+		if known == nil {
+			continue
+		}
+		found := false
+		for _, k := range known {
+			if key.Value == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return t.errorf(key, "unknown key '%s'", key.Value)
+		}
+	}
+	return nil
+}
+
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// Params returns template parameters with default values
+func (t *DeviceTemplate) Params() map[string]string {
+	res := make(map[string]string, len(t.defaults))
+	for k, v := range t.defaults {
+		res[k] = v
+	}
+	return res
+}
+
+// Render renders device descriptions with given parameters.
+// Parameters not set by caller get default values from template
+func (t *DeviceTemplate) Render(params map[string]any) ([]DeviceSpec, error) {
+	vars := t.Params()
+	for k, v := range params {
+		vars[k] = fmt.Sprint(v)
+	}
+
+	devices := mappingValue(t.root, "devices")
+	res := make([]DeviceSpec, 0, len(devices.Content))
+	ids := make(map[string]bool)
+	for _, devNode := range devices.Content {
+		dev, err := t.renderDevice(devNode, vars)
+		if err != nil {
+			return nil, err
+		}
+		if ids[dev.Id] {
+			return nil, t.errorf(devNode, "duplicate device id '%s'", dev.Id)
+		}
+		ids[dev.Id] = true
+		res = append(res, dev)
+	}
+	return res, nil
+}
+
+func (t *DeviceTemplate) renderDevice(n *yaml.Node, vars map[string]string) (dev DeviceSpec, err error) {
+	if dev.Id, err = t.str(mappingValue(n, "id"), vars); err != nil {
+		return
+	}
+	if v := mappingValue(n, "title"); v != nil {
+		if dev.Title, err = t.title(v, vars); err != nil {
+			return
+		}
+	}
+	if v := mappingValue(n, "virtual"); v != nil {
+		if dev.Virtual, err = t.bool(v, vars); err != nil {
+			return
+		}
+	}
+	if v := mappingValue(n, "load_previous"); v != nil {
+		if dev.LoadPrevious, err = t.bool(v, vars); err != nil {
+			return
+		}
+	}
+
+	if v := mappingValue(n, "meta"); v != nil {
+		if dev.ExtraMeta, err = t.extraMeta(v, vars, true); err != nil {
+			return
+		}
+	}
+
+	controls := mappingValue(n, "controls")
+	if controls == nil {
+		return
+	}
+	ids := make(map[string]bool)
+	for _, ctrlNode := range controls.Content {
+		count := 1
+		if v := mappingValue(ctrlNode, "repeat"); v != nil {
+			if count, err = t.int(v, vars); err != nil {
+				return
+			}
+		}
+		for i := 0; i < count; i++ {
+			ctrlVars := vars
+			if mappingValue(ctrlNode, "repeat") != nil {
+				ctrlVars = make(map[string]string, len(vars)+2)
+				for k, v := range vars {
+					ctrlVars[k] = v
+				}
+				ctrlVars["i"] = strconv.Itoa(i)
+				ctrlVars["n"] = strconv.Itoa(i + 1)
+			}
+			var ctrl ControlSpec
+			if ctrl, err = t.renderControl(ctrlNode, ctrlVars); err != nil {
+				return
+			}
+			if ids[ctrl.Id] {
+				err = t.errorf(ctrlNode, "duplicate control id '%s'", ctrl.Id)
+				return
+			}
+			ids[ctrl.Id] = true
+			dev.Controls = append(dev.Controls, ctrl)
+		}
+	}
+	return
+}
+
+func (t *DeviceTemplate) renderControl(n *yaml.Node, vars map[string]string) (ctrl ControlSpec, err error) {
+	if ctrl.Id, err = t.str(mappingValue(n, "id"), vars); err != nil {
+		return
+	}
+	if ctrl.Type, err = t.str(mappingValue(n, "type"), vars); err != nil {
+		return
+	}
+	for i := 0; i < len(n.Content); i += 2 {
+		key, v := n.Content[i].Value, n.Content[i+1]
+		switch key {
+		case "title":
+			ctrl.Title, err = t.title(v, vars)
+		case "description":
+			ctrl.Description, err = ptr(t.str(v, vars))
+		case "units":
+			ctrl.Units, err = ptr(t.str(v, vars))
+		case "value":
+			ctrl.Value, err = ptr(t.str(v, vars))
+		case "readonly":
+			ctrl.Readonly, err = ptr(t.bool(v, vars))
+		case "load_previous":
+			ctrl.LoadPrevious, err = ptr(t.bool(v, vars))
+		case "lazy_init":
+			ctrl.LazyInit, err = ptr(t.bool(v, vars))
+		case "min":
+			ctrl.Min, err = ptr(t.float(v, vars))
+		case "max":
+			ctrl.Max, err = ptr(t.float(v, vars))
+		case "precision":
+			ctrl.Precision, err = ptr(t.float(v, vars))
+		case "order":
+			ctrl.Order, err = ptr(t.int(v, vars))
+		case "enum":
+			ctrl.EnumTitles, err = t.enum(v, vars)
+		case "meta":
+			ctrl.ExtraMeta, err = t.extraMeta(v, vars, false)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func ptr[T any](v T, err error) (*T, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// str returns scalar value with all parameters expanded
+func (t *DeviceTemplate) str(n *yaml.Node, vars map[string]string) (string, error) {
+	if n.Kind != yaml.ScalarNode {
+		return "", t.errorf(n, "scalar value expected")
+	}
+	var err error
+	res := templateParamRegexp.ReplaceAllStringFunc(n.Value, func(m string) string {
+		name := templateParamRegexp.FindStringSubmatch(m)[1]
+		v, ok := vars[name]
+		if !ok && err == nil {
+			err = t.errorf(n, "unknown parameter '%s'", name)
+		}
+		return v
+	})
+	return res, err
+}
+
+func (t *DeviceTemplate) bool(n *yaml.Node, vars map[string]string) (bool, error) {
+	s, err := t.str(n, vars)
+	if err != nil {
+		return false, err
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, t.errorf(n, "boolean expected, got '%s'", s)
+	}
+	return v, nil
+}
+
+func (t *DeviceTemplate) int(n *yaml.Node, vars map[string]string) (int, error) {
+	s, err := t.str(n, vars)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, t.errorf(n, "integer expected, got '%s'", s)
+	}
+	return v, nil
+}
+
+func (t *DeviceTemplate) float(n *yaml.Node, vars map[string]string) (float64, error) {
+	s, err := t.str(n, vars)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, t.errorf(n, "number expected, got '%s'", s)
+	}
+	return v, nil
+}
+
+// title accepts either plain string (english title) or language map
+func (t *DeviceTemplate) title(n *yaml.Node, vars map[string]string) (Title, error) {
+	if n.Kind == yaml.ScalarNode {
+		s, err := t.str(n, vars)
+		if err != nil {
+			return nil, err
+		}
+		return Title{"en": s}, nil
+	}
+	if err := t.checkMapping(n, nil); err != nil {
+		return nil, err
+	}
+	title := make(Title, len(n.Content)/2)
+	for i := 0; i < len(n.Content); i += 2 {
+		s, err := t.str(n.Content[i+1], vars)
+		if err != nil {
+			return nil, err
+		}
+		title[n.Content[i].Value] = s
+	}
+	return title, nil
+}
+
+func (t *DeviceTemplate) enum(n *yaml.Node, vars map[string]string) (map[string]Title, error) {
+	if err := t.checkMapping(n, nil); err != nil {
+		return nil, err
+	}
+	enum := make(map[string]Title, len(n.Content)/2)
+	for i := 0; i < len(n.Content); i += 2 {
+		key, err := t.str(n.Content[i], vars)
+		if err != nil {
+			return nil, err
+		}
+		if enum[key], err = t.title(n.Content[i+1], vars); err != nil {
+			return nil, err
+		}
+	}
+	return enum, nil
+}
+
+// extraMeta reads custom meta keys, values are kept as strings
+func (t *DeviceTemplate) extraMeta(n *yaml.Node, vars map[string]string, isDevice bool) (MetaInfo, error) {
+	if err := t.checkMapping(n, nil); err != nil {
+		return nil, err
+	}
+	meta := make(MetaInfo, len(n.Content)/2)
+	for i := 0; i < len(n.Content); i += 2 {
+		key := n.Content[i].Value
+		if err := ValidateExtraMetaKey(key, isDevice); err != nil {
+			return nil, t.errorf(n.Content[i], "%v", err)
+		}
+		value, err := t.str(n.Content[i+1], vars)
+		if err != nil {
+			return nil, err
+		}
+		meta[key] = value
+	}
+	return meta, nil
+}
+
+// LocalDeviceArgs returns arguments to create device described by spec
+func (spec *DeviceSpec) LocalDeviceArgs() LocalDeviceArgs {
+	args := NewLocalDeviceArgs().
+		SetId(spec.Id).
+		SetVirtual(spec.Virtual).
+		SetDoLoadPrevious(spec.LoadPrevious)
+	if spec.Title != nil {
+		args.SetTitle(spec.Title)
+	}
+	for key, value := range spec.ExtraMeta {
+		args.SetExtraMeta(key, value)
+	}
+	return args
+}
+
+// ControlArgs returns arguments to create control described by spec
+func (spec *ControlSpec) ControlArgs() ControlArgs {
+	args := NewControlArgs().
+		SetId(spec.Id).
+		SetType(spec.Type)
+	if spec.Title != nil {
+		args.SetTitle(spec.Title)
+	}
+	if spec.Description != nil {
+		args.SetDescription(*spec.Description)
+	}
+	if spec.Units != nil {
+		args.SetUnits(*spec.Units)
+	}
+	if spec.Readonly != nil {
+		args.SetReadonly(*spec.Readonly)
+	}
+	if spec.Min != nil {
+		args.SetMin(*spec.Min)
+	}
+	if spec.Max != nil {
+		args.SetMax(*spec.Max)
+	}
+	if spec.Precision != nil {
+		args.SetPrecision(*spec.Precision)
+	}
+	if spec.Order != nil {
+		args.SetOrder(*spec.Order)
+	}
+	if spec.EnumTitles != nil {
+		args.SetEnumTitles(spec.EnumTitles)
+	}
+	if spec.Value != nil {
+		args.SetRawValue(*spec.Value)
+	}
+	if spec.LoadPrevious != nil {
+		args.SetDoLoadPrevious(*spec.LoadPrevious)
+	}
+	if spec.LazyInit != nil {
+		args.SetLazyInit(*spec.LazyInit)
+	}
+	for key, value := range spec.ExtraMeta {
+		args.SetExtraMeta(key, value)
+	}
+	return args
+}
+
+// Create renders template with given parameters and creates all described
+// local devices and their controls in driver.
+// If some device or control can't be created, already created devices are removed
+func (t *DeviceTemplate) Create(tx DriverTx, params map[string]any) ([]LocalDevice, error) {
+	specs, err := t.Render(params)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]LocalDevice, 0, len(specs))
+	for _, spec := range specs {
+		dev, err := createDeviceFromSpec(tx, spec)
+		if err != nil {
+			removeDevices(tx, devices)
+			return nil, err
+		}
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+// createDeviceFromSpec creates device with all its controls,
+// device is removed if some control can't be created
+func createDeviceFromSpec(tx DriverTx, spec DeviceSpec) (LocalDevice, error) {
+	dev, err := tx.CreateDevice(spec.LocalDeviceArgs())()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create device %s: %w", spec.Id, err)
+	}
+	for _, ctrl := range spec.Controls {
+		if _, err := dev.CreateControl(ctrl.ControlArgs())(); err != nil {
+			removeDevices(tx, []LocalDevice{dev})
+			return nil, fmt.Errorf("failed to create control %s/%s: %w", spec.Id, ctrl.Id, err)
+		}
+	}
+	return dev, nil
+}
+
+// removeDevices removes devices created before failure, errors are logged only
+func removeDevices(tx DriverTx, devices []LocalDevice) {
+	for _, dev := range devices {
+		if err := tx.RemoveDevice(dev)(); err != nil {
+			Error.Printf("failed to remove device %s: %v", dev.GetId(), err)
+		}
+	}
+}
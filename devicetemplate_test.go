@@ -0,0 +1,116 @@
+package wbgong
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTemplate = `params:
+  name: kitchen
+  channels: 2
+devices:
+  - id: relays_${name}
+    title: {en: "Relays (${name})", ru: "Реле (${name})"}
+    virtual: true
+    meta:
+      serial: "0001"
+    controls:
+      - id: K${n}
+        repeat: ${channels}
+        type: switch
+        readonly: false
+        title: Relay ${n}
+        order: ${i}
+      - id: temp
+        type: temperature
+        readonly: true
+        precision: 0.1
+        enum: {"0": Off}
+`
+
+func TestDeviceTemplateRender(t *testing.T) {
+	tmpl, err := ParseDeviceTemplate("test.yaml", []byte(testTemplate))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "kitchen", "channels": "2"}, tmpl.Params())
+
+	devices, err := tmpl.Render(map[string]any{"channels": 3})
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	dev := devices[0]
+	assert.Equal(t, "relays_kitchen", dev.Id)
+	assert.Equal(t, Title{"en": "Relays (kitchen)", "ru": "Реле (kitchen)"}, dev.Title)
+	assert.True(t, dev.Virtual)
+	assert.Equal(t, MetaInfo{"serial": "0001"}, dev.ExtraMeta)
+
+	require.Len(t, dev.Controls, 4)
+	for i, ctrl := range dev.Controls[:3] {
+		n := string(rune('1' + i))
+		assert.Equal(t, "K"+n, ctrl.Id)
+		assert.Equal(t, Title{"en": "Relay " + n}, ctrl.Title)
+		require.NotNil(t, ctrl.Order)
+		assert.Equal(t, i, *ctrl.Order)
+		require.NotNil(t, ctrl.Readonly)
+		assert.False(t, *ctrl.Readonly)
+	}
+	temp := dev.Controls[3]
+	assert.Equal(t, "temp", temp.Id)
+	assert.Equal(t, 0.1, *temp.Precision)
+	assert.Equal(t, map[string]Title{"0": {"en": "Off"}}, temp.EnumTitles)
+	assert.Nil(t, temp.Order)
+}
+
+func TestDeviceTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		params map[string]any
+		line   int
+		column int
+		msg    string
+	}{
+		{name: "yaml syntax", data: "params: {}\ndevices: [\n", line: 2, column: 1,
+			msg: "did not find expected node content"},
+		{name: "empty", data: "", line: 1, column: 1, msg: "empty template"},
+		{name: "not object", data: "- a\n", line: 1, column: 1, msg: "object expected"},
+		{name: "unknown root key", data: "devices: []\nfoo: 1\n", line: 2, column: 1, msg: "unknown key 'foo'"},
+		{name: "no devices", data: "params: {a: 1}\n", line: 1, column: 1, msg: "no devices defined"},
+		{name: "devices not list", data: "devices: {}\n", line: 1, column: 10, msg: "devices must be a list"},
+		{name: "non-scalar param", data: "params:\n  a: [1]\ndevices: []\n", line: 2, column: 6,
+			msg: "parameter 'a' must be a scalar"},
+		{name: "device id missing", data: "devices:\n  - title: x\n", line: 2, column: 5, msg: "device id is missing"},
+		{name: "unknown control key", data: "devices:\n  - id: d\n    controls:\n      - id: c\n        foo: 1\n",
+			line: 5, column: 9, msg: "unknown key 'foo'"},
+		{name: "readonly missing", data: "devices:\n  - id: d\n    controls:\n      - id: c\n        type: value\n",
+			line: 4, column: 9, msg: "control readonly is missing"},
+		{name: "unknown parameter", data: "devices:\n  - id: d_${x}\n", line: 2, column: 9,
+			msg: "unknown parameter 'x'"},
+		{name: "bad bool", data: "devices:\n  - id: d\n    virtual: ${v}\n", params: map[string]any{"v": "maybe"},
+			line: 3, column: 14, msg: "boolean expected, got 'maybe'"},
+		{name: "bad repeat", data: "devices:\n  - id: d\n    controls:\n      - {id: c, type: value, readonly: true, repeat: x}\n",
+			line: 4, column: 54, msg: "integer expected, got 'x'"},
+		{name: "duplicate control", data: "devices:\n  - id: d\n    controls:\n      - {id: c, type: value, readonly: true, repeat: 2}\n",
+			line: 4, column: 9, msg: "duplicate control id 'c'"},
+		{name: "duplicate device", data: "devices:\n  - id: d\n  - id: d\n", line: 3, column: 5,
+			msg: "duplicate device id 'd'"},
+		{name: "reserved meta", data: "devices:\n  - id: d\n    meta: {driver: x}\n", line: 3, column: 12},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := ParseDeviceTemplate("test.yaml", []byte(tc.data))
+			if err == nil {
+				_, err = tmpl.Render(tc.params)
+			}
+			var templateErr *TemplateError
+			require.True(t, errors.As(err, &templateErr), "%v", err)
+			assert.Equal(t, "test.yaml", templateErr.File)
+			assert.Equal(t, tc.line, templateErr.Line, templateErr.Error())
+			assert.Equal(t, tc.column, templateErr.Column, templateErr.Error())
+			if tc.msg != "" {
+				assert.Equal(t, tc.msg, templateErr.Msg)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package wbgong
 
 import (
+	"context"
 	"log"
 	"os"
 	"time"
@@ -10,6 +11,9 @@ var (
 	funcNewDriverArgs func() DriverArgs
 )
 
+// DefaultEventQueueSize is a default capacity of driver event queue
+const DefaultEventQueueSize = 1024
+
 // HandlerID is an index of specific event handler
 type HandlerID int
 
@@ -22,7 +26,20 @@ type DriverArgs interface {
 	SetUseStorage(v bool) DriverArgs
 	SetStoragePath(path string) DriverArgs
 	SetStorageMode(mode os.FileMode) DriverArgs
+	// SetStorage sets storage for previous values, it overrides
+	// built-in storage configured by SetUseStorage/SetStoragePath
+	SetStorage(s Storage) DriverArgs
 	SetReownUnknownDevices(v bool) DriverArgs
+	// SetMetaPublishMode selects meta formats published for local devices.
+	// Use CONV_META_MODE_BOTH during migration from legacy meta subtopics
+	SetMetaPublishMode(mode MetaPublishMode) DriverArgs
+	// SetEventQueueSize sets capacity of queue between backend and frontend
+	// (DefaultEventQueueSize if not set)
+	SetEventQueueSize(size int) DriverArgs
+	// SetEventQueueOverflow sets event queue overflow policy (OverflowDropOldest if not set).
+	// OverflowCoalesce merges ControlValueEvents for the same control, which is
+	// useful under retained messages storms
+	SetEventQueueOverflow(policy OverflowPolicy) DriverArgs
 	Finalize()
 	GetBackend() DriverBackend
 	GetID() string
@@ -31,6 +48,10 @@ type DriverArgs interface {
 	GetUseStorage() bool
 	GetStoragePath() string
 	GetStorageMode() os.FileMode
+	GetStorage() Storage
+	GetMetaPublishMode() MetaPublishMode
+	GetEventQueueSize() int
+	GetEventQueueOverflow() OverflowPolicy
 }
 
 // DriverBackend is a backend interface for Driver
@@ -58,11 +79,26 @@ type DriverBackend interface {
 	UpdateDeviceMeta(dev LocalDevice, meta string, value any) <-chan error
 	UpdateDeviceMetaJson(dev LocalDevice) <-chan error
 
+	// publishes all batch changes back to back
+	ApplyControlBatch(b *ControlBatch) <-chan error
+
 	// these are sent by suicide devices (when all device/control info is cleared)
 	RemoveExternalDevice(dev ExternalDevice)
 	RemoveExternalControl(ctrl Control)
 }
 
+// IncrementalFilterBackend is implemented by backends which can change
+// external devices filter without reloading all retained messages.
+// Driver checks for it with type assertion and falls back to SetFilter
+type IncrementalFilterBackend interface {
+	// AddToFilter subscribes to topics of added filter,
+	// channel is signaled after retained messages for them are received
+	AddToFilter(f DeviceFilter) <-chan struct{}
+	// RemoveFromFilter unsubscribes from topics not needed anymore
+	// and removes external devices and controls which don't match remaining filters
+	RemoveFromFilter(f DeviceFilter) <-chan struct{}
+}
+
 // DriverFrontend is an object DriverBackend interacts with
 // DriverFrontend represents Driver as part of FB pair, providing
 // special methodes for DriverBackend
@@ -73,6 +109,8 @@ type DriverFrontend interface {
 	// Push driver event into processing queue
 	// If block parameter is true, function will block until event is pushed
 	// Otherwise, is event queue is full, PushEvent will return EventQueueFullErrror
+	// Queue overflow behaviour is set by DriverArgs.SetEventQueueOverflow,
+	// dropped events are counted in Driver.Stats()
 	PushEvent(event DriverEvent)
 
 	// Connect backend
@@ -87,6 +125,9 @@ type DriverFrontend interface {
 //
 // Driver stores Devices - user's representation of MQTT devices
 // User can interact with them synchronously with driver
+//
+// Driver is implemented by wbgo plugin, so plugin must be built
+// against the same wbgong revision as the application (see Init)
 type Driver interface {
 	//
 	// Userspace methods
@@ -98,12 +139,31 @@ type Driver interface {
 	// GetId returns driver ID from MQTT (published in /devices/+/meta/driver)
 	GetId() string
 
+	// Stats returns driver internals statistics (event queue depth, drops, etc.)
+	Stats() DriverStats
+
+	// ReadTx returns immutable consistent view of devices and controls.
+	// It doesn't block driver loop, so it's suitable for polling state
+	// from other goroutines. Snapshots returned by view are shared and must not be modified
+	ReadTx() ReadTx
+
 	// SetFilter sets external device filter (NoDevices set by default to omit
 	// all external devices).
 	// After SetFilter call, backend will reload retained messages, so Ready event will
 	// be emitted after
 	SetFilter(filter DeviceFilter)
 
+	// AddToFilter extends current filter with given one (see FilterSet).
+	// NewExternalDeviceEvent is emitted for newly matched devices; ReadyEvent is not emitted
+	// unless backend doesn't implement IncrementalFilterBackend and whole filter is reloaded
+	AddToFilter(filter DeviceFilter)
+
+	// RemoveFromFilter removes filter previously added by AddToFilter or SetFilter.
+	// Topics not needed anymore are unsubscribed, ExternalDeviceRemovedEvent and
+	// ExternalDeviceControlRemovedEvent are emitted for devices and controls
+	// which don't match remaining filters
+	RemoveFromFilter(filter DeviceFilter)
+
 	// LoopOnce tries to receive an event from event queue and process it
 	// It quits if quit signal received or timeout occures.
 	//
@@ -112,6 +172,11 @@ type Driver interface {
 	// User-defined event handlers also run in loop
 	LoopOnce(timeout time.Duration) bool
 
+	// LoopContext processes events until context is done or quit signal received.
+	// Returns nil on quit signal, DriverTimeoutError or DriverCanceledError
+	// otherwise (see ContextError)
+	LoopContext(ctx context.Context) error
+
 	// StartLoop
 	StartLoop() error
 
@@ -121,7 +186,7 @@ type Driver interface {
 	// and locks driver thread to synchronize data access.
 	//
 	// Don't forget to close transaction after you're done
-	// (by using tx.End())
+	// (by using tx.Commit() or tx.Rollback())
 	BeginTx() (DriverTx, error)
 
 	// Access executes user function with transaction and automatically
@@ -129,6 +194,8 @@ type Driver interface {
 	// exclusive access for reader
 	//
 	// If user function returns error, Access will pass it through.
+	// Transaction is committed if user function returns nil and
+	// rolled back otherwise (see DriverTx).
 	//
 	// Note that DriverTx object here is only valid within the function.
 	Access(thunk func(tx DriverTx) error) error
@@ -138,6 +205,11 @@ type Driver interface {
 	// passes error through.
 	AccessAsync(thunk func(tx DriverTx) error) func() error
 
+	// AccessContext works like Access but gives up waiting for driver loop
+	// when context is done. Thunk is not started in that case; already started thunk
+	// can't be interrupted, but AccessContext returns immediately (see ContextError)
+	AccessContext(ctx context.Context, thunk func(tx DriverTx) error) error
+
 	// OnRetainReady executes given function in driver loop if
 	// all retained messages are received (after ReadyEvent{})
 	// To react on each ReadyEvent{}, you need to register function again and again.
@@ -147,13 +219,48 @@ type Driver interface {
 	// WaitForReady locks until next ReadyEvent received
 	WaitForReady()
 
+	// WaitForReadyContext locks until next ReadyEvent received or context is done
+	WaitForReadyContext(ctx context.Context) error
+
 	// OnDriverEvent allows user application to handle backend events.
 	// Handler runs in frontend loop, so all devices access operations are safe.
 	OnDriverEvent(handler func(e DriverEvent)) HandlerID
 
 	// Removes OnDriverEvent handler
+	// Pattern subscriptions (OnControlValue etc.) are removed by this method too
 	RemoveOnDriverEventHandler(handlerID HandlerID)
 
+	// Events returns stream of driver events delivered to channel
+	// with per-subscriber buffering (see NewEventStream).
+	// Stream is returned instead of plain <-chan DriverEvent because subscription
+	// must be closed and buffer stats are needed to detect slow consumers;
+	// use EventStream.C() to get the channel.
+	// Close the stream when it's not needed anymore
+	Events(opts EventStreamOptions) *EventStream
+
+	// OnControlValue subscribes to ControlValueEvent of controls matching pattern
+	// (see EventPattern for syntax). Handler runs in frontend loop
+	OnControlValue(pattern string, handler func(e ControlValueEvent)) (HandlerID, error)
+
+	// OnControlOn subscribes to ControlOnValueEvent of local controls matching pattern
+	OnControlOn(pattern string, handler func(e ControlOnValueEvent)) (HandlerID, error)
+
+	// OnNewExternalDevice subscribes to NewExternalDeviceEvent of devices matching pattern
+	OnNewExternalDevice(pattern string, handler func(e NewExternalDeviceEvent)) (HandlerID, error)
+
+	// OnMeta subscribes to device ("device" pattern) or control ("device/control" pattern)
+	// meta events of given type (any type if empty)
+	OnMeta(pattern, metaType string, handler func(e DriverEvent)) (HandlerID, error)
+
+	// AddOnValueInterceptor adds interceptor for '/on' values of all local controls.
+	// Interceptors run in frontend loop before ControlOnValueEvent is delivered;
+	// rejected values are reflected to MQTT (see ReflectRejectedOnValue)
+	// and ControlOnValueRejectedEvent is emitted
+	AddOnValueInterceptor(f OnValueInterceptor) HandlerID
+
+	// Removes '/on' value interceptor
+	RemoveOnValueInterceptor(id HandlerID)
+
 	// Close closes all opened files and connections
 	Close()
 
@@ -181,6 +288,15 @@ type DeviceDriver interface {
 	NeedToReownUnknownDevices() bool
 }
 
+// DriverStats contains driver internals statistics
+type DriverStats struct {
+	// Event queue between backend and frontend
+	Queue EventBufferStats
+
+	// Number of events processed by frontend loop
+	EventsProcessed uint64
+}
+
 // DriverEvent is a driver event representation for Driver
 // Driver sends events to Drivers via Driver.PushEvent(event)
 // Implements Stringer
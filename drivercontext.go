@@ -0,0 +1,91 @@
+package wbgong
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// loopContextPollInterval limits LoopOnce waiting time in LoopContext
+// so cancellation is noticed quickly
+const loopContextPollInterval = 100 * time.Millisecond
+
+// ContextError converts context error to driver error:
+// DriverTimeoutError for expired deadlines and DriverCanceledError for cancellation.
+// Original context error is wrapped too, so errors.Is works for both
+func ContextError(ctx context.Context) error {
+	err := ctx.Err()
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", DriverTimeoutError, err)
+	default:
+		return fmt.Errorf("%w: %w", DriverCanceledError, err)
+	}
+}
+
+// AccessContext is a generic implementation of Driver.AccessContext based on AccessAsync.
+// Thunk is not started if context is done before driver loop picks it up;
+// once started, thunk runs to completion, but AccessContext returns on context expiration
+func AccessContext(ctx context.Context, d Driver, thunk func(tx DriverTx) error) error {
+	if err := ContextError(ctx); err != nil {
+		return err
+	}
+	future := d.AccessAsync(func(tx DriverTx) error {
+		if err := ContextError(ctx); err != nil {
+			return err
+		}
+		return thunk(tx)
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- future()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ContextError(ctx)
+	}
+}
+
+// WaitForReadyContext is a generic implementation of Driver.WaitForReadyContext
+// based on OnRetainReady. OnRetainReady callbacks can't be removed,
+// so callback registered here does nothing after context is done
+func WaitForReadyContext(ctx context.Context, d Driver) error {
+	ready := make(chan struct{})
+	var once sync.Once
+	d.OnRetainReady(func(tx DriverTx) {
+		once.Do(func() {
+			close(ready)
+		})
+	})
+	// callback becomes no-op once we stop waiting
+	defer once.Do(func() {})
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ContextError(ctx)
+	}
+}
+
+// LoopContext is a generic implementation of Driver.LoopContext based on LoopOnce.
+// It returns nil if driver quit signal received
+func LoopContext(ctx context.Context, d Driver) error {
+	for {
+		if err := ContextError(ctx); err != nil {
+			return err
+		}
+		timeout := loopContextPollInterval
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+			timeout = time.Until(deadline)
+		}
+		if timeout > 0 && !d.LoopOnce(timeout) {
+			return nil
+		}
+	}
+}
@@ -26,6 +26,7 @@ var (
 	DriverInactiveError       = errors.New("Driver loop is not running")
 	DriverWrongArgumentsError = errors.New("Wrong arguments set for NewDriver")
 	DriverTimeoutError        = errors.New("Driver timeout error")
+	DriverCanceledError       = errors.New("Driver operation canceled")
 	DeviceRedefinitionError   = errors.New("Device redefinition")
 	ControlRedefinitionError  = errors.New("Control redefinition")
 	NonLocalControlError      = errors.New("Trying to register non-local control")
@@ -36,12 +37,24 @@ var (
 	IncorrectDeviceIdError    = errors.New("Device ID has incorrect symbols")
 	StorageUnavailableError   = errors.New("External storage is not initialized")
 	StorageValueNotFoundError = errors.New("No value in storage")
+	RetainedTimeoutError      = errors.New("Timeout waiting for retained messages")
+	HistoryEmptyError         = errors.New("No values in control history")
+	ComputedCycleError        = errors.New("Computed control depends on itself")
+	ExprSyntaxError           = errors.New("Expression syntax error")
+	ExprUnknownVariableError  = errors.New("Unknown variable in expression")
+	ExprDivisionByZeroError   = errors.New("Division by zero in expression")
+
+	UnsupportedSnapshotVersionError = errors.New("Unsupported snapshot version")
 
 	LocalDeviceError    = errors.New("Device is local")
 	ExternalDeviceError = errors.New("Device is external")
 	BackendActiveError  = errors.New("Driver backend is running already")
 
 	UnknownDeviceMetaError    = errors.New("Unknown device meta type")
+	ReservedMetaKeyError      = errors.New("Meta key is reserved")
+	IncorrectMetaKeyError     = errors.New("Meta key is incorrect")
+	MetaKeyNotFoundError      = errors.New("No such meta key")
+	IncorrectPatternError     = errors.New("Device/control pattern is incorrect")
 	ControlAlreadyExistsError = errors.New("Control already exists")
 	NoSuchControlError        = errors.New("No such control")
 	LocalDeviceArgumentsError = errors.New("Wrong local device arguments list, check required fields")
@@ -52,11 +65,14 @@ var (
 	LocalControlError       = errors.New("This control is local")
 	WrongValueError         = errors.New("Wrong value")
 	WrongValueTypeError     = errors.New("Wrong value type")
+	WrongControlTypeError   = errors.New("Wrong control type")
 	UnknownControlMetaError = errors.New("Unknown control meta type")
 	IncompleteControlError  = errors.New("This control is incomplete")
 	ControlDeletedError     = errors.New("This control was deleted")
 	IncorrectControlIdError = errors.New("Control ID is incorrect")
 	NoTxContextError        = errors.New("No Tx context")
+	TxDoneError             = errors.New("Transaction is already finished")
+	TxRolledBackError       = errors.New("Transaction is rolled back")
 	NotWritableControlError = errors.New("This control is not writable")
 	ReadonlyMissingError    = errors.New("Missing of mandatory readonly argument")
 )
@@ -0,0 +1,224 @@
+package wbgong
+
+import (
+	"sync"
+	"time"
+)
+
+// OverflowPolicy selects EventBuffer behaviour when it's full.
+// Zero value is OverflowDropOldest, so unset policy never stalls producer
+type OverflowPolicy int
+
+const (
+	// Drop oldest buffered event
+	OverflowDropOldest OverflowPolicy = iota
+	// Wait until there is free space
+	OverflowBlock
+	// Drop new event
+	OverflowDropNewest
+	// Merge new ControlValueEvent into buffered one for the same control
+	// (keeping its PrevRawValue); other events wait for free space
+	OverflowCoalesce
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDropOldest:
+		return "drop_oldest"
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop_newest"
+	case OverflowCoalesce:
+		return "coalesce"
+	default:
+		return "unknown"
+	}
+}
+
+// EventBufferStats contains EventBuffer counters
+type EventBufferStats struct {
+	Len       int
+	Capacity  int
+	HighWater int
+	Pushed    uint64
+	Dropped   uint64
+	Coalesced uint64
+}
+
+// EventBuffer is a bounded FIFO of driver events with configurable overflow policy.
+// EventBuffer is safe for concurrent use
+type EventBuffer struct {
+	mu       sync.Mutex
+	capacity int
+	policy   OverflowPolicy
+	items    []DriverEvent // ring buffer
+	head     int
+	count    int
+	closed   bool
+	stats    EventBufferStats
+	dataCh   chan struct{}
+	spaceCh  chan struct{}
+	closedCh chan struct{}
+}
+
+// NewEventBuffer creates buffer with given capacity (at least 1)
+func NewEventBuffer(capacity int, policy OverflowPolicy) *EventBuffer {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &EventBuffer{
+		capacity: capacity,
+		policy:   policy,
+		items:    make([]DriverEvent, capacity),
+		dataCh:   make(chan struct{}, 1),
+		spaceCh:  make(chan struct{}, 1),
+		closedCh: make(chan struct{}),
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Push adds event to buffer according to overflow policy.
+// It returns false if event was dropped (or buffer is closed)
+func (b *EventBuffer) Push(e DriverEvent) bool {
+	for {
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return false
+		}
+		if b.count < b.capacity {
+			b.append(e)
+			b.mu.Unlock()
+			return true
+		}
+
+		switch b.policy {
+		case OverflowDropNewest:
+			b.stats.Dropped++
+			b.mu.Unlock()
+			return false
+		case OverflowDropOldest:
+			b.items[b.head] = nil
+			b.head = (b.head + 1) % b.capacity
+			b.count--
+			b.stats.Dropped++
+			b.append(e)
+			b.mu.Unlock()
+			return true
+		case OverflowCoalesce:
+			if b.coalesce(e) {
+				b.mu.Unlock()
+				return true
+			}
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-b.spaceCh:
+		case <-b.closedCh:
+		}
+	}
+}
+
+// append adds event, must be called with lock held
+func (b *EventBuffer) append(e DriverEvent) {
+	b.items[(b.head+b.count)%b.capacity] = e
+	b.count++
+	b.stats.Pushed++
+	if b.count > b.stats.HighWater {
+		b.stats.HighWater = b.count
+	}
+	signal(b.dataCh)
+}
+
+// coalesce merges value event into buffered one for the same control
+// and batch, must be called with lock held
+func (b *EventBuffer) coalesce(e DriverEvent) bool {
+	ev, ok := e.(ControlValueEvent)
+	if !ok {
+		return false
+	}
+	for n := b.count - 1; n >= 0; n-- {
+		i := (b.head + n) % b.capacity
+		old, ok := b.items[i].(ControlValueEvent)
+		if ok && old.Control == ev.Control && old.BatchId == ev.BatchId {
+			ev.PrevRawValue = old.PrevRawValue
+			b.items[i] = ev
+			b.stats.Coalesced++
+			return true
+		}
+	}
+	return false
+}
+
+// Pop waits for event and removes it from buffer.
+// Negative timeout means no timeout. False is returned on timeout or if buffer is closed and empty
+func (b *EventBuffer) Pop(timeout time.Duration) (DriverEvent, bool) {
+	var timeoutCh <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	for {
+		b.mu.Lock()
+		if b.count > 0 {
+			e := b.items[b.head]
+			b.items[b.head] = nil
+			b.head = (b.head + 1) % b.capacity
+			b.count--
+			if b.count > 0 {
+				signal(b.dataCh)
+			}
+			signal(b.spaceCh)
+			b.mu.Unlock()
+			return e, true
+		}
+		closed := b.closed
+		b.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+
+		select {
+		case <-b.dataCh:
+		case <-b.closedCh:
+		case <-timeoutCh:
+			return nil, false
+		}
+	}
+}
+
+// Len returns number of buffered events
+func (b *EventBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.count
+}
+
+// Stats returns buffer counters
+func (b *EventBuffer) Stats() EventBufferStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.Len = b.count
+	stats.Capacity = b.capacity
+	return stats
+}
+
+// Close closes buffer: pushes fail, pops return remaining events and then fail
+func (b *EventBuffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.closedCh)
+	}
+}
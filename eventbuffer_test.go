@@ -0,0 +1,139 @@
+package wbgong
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func valueEvent(ctrl Control, prev, value string) ControlValueEvent {
+	return ControlValueEvent{Control: ctrl, RawValue: value, PrevRawValue: prev}
+}
+
+// popAll pops buffered events without waiting
+func popAll(b *EventBuffer) []DriverEvent {
+	var res []DriverEvent
+	for {
+		e, ok := b.Pop(0)
+		if !ok {
+			return res
+		}
+		res = append(res, e)
+	}
+}
+
+func TestOverflowPolicyDefault(t *testing.T) {
+	var policy OverflowPolicy
+	assert.Equal(t, OverflowDropOldest, policy)
+	assert.Equal(t, "drop_oldest", policy.String())
+	assert.Equal(t, OverflowDropOldest, EventStreamOptions{}.Overflow)
+}
+
+func TestEventBufferDrop(t *testing.T) {
+	k1 := newRouterControl("dev", "K1")
+	tests := []struct {
+		policy OverflowPolicy
+		pushed []bool
+		events []DriverEvent
+		stats  EventBufferStats
+	}{
+		{
+			policy: OverflowDropOldest,
+			pushed: []bool{true, true, true, true},
+			events: []DriverEvent{valueEvent(k1, "1", "2"), valueEvent(k1, "2", "3")},
+			stats:  EventBufferStats{Len: 2, Capacity: 2, HighWater: 2, Pushed: 4, Dropped: 2},
+		},
+		{
+			policy: OverflowDropNewest,
+			pushed: []bool{true, true, false, false},
+			events: []DriverEvent{valueEvent(k1, "", "0"), valueEvent(k1, "0", "1")},
+			stats:  EventBufferStats{Len: 2, Capacity: 2, HighWater: 2, Pushed: 2, Dropped: 2},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			b := NewEventBuffer(2, tc.policy)
+			pushed := []bool{
+				b.Push(valueEvent(k1, "", "0")),
+				b.Push(valueEvent(k1, "0", "1")),
+				b.Push(valueEvent(k1, "1", "2")),
+				b.Push(valueEvent(k1, "2", "3")),
+			}
+			assert.Equal(t, tc.pushed, pushed)
+			assert.Equal(t, tc.stats, b.Stats())
+			assert.Equal(t, tc.events, popAll(b))
+			assert.Zero(t, b.Len())
+		})
+	}
+}
+
+func TestEventBufferBlock(t *testing.T) {
+	b := NewEventBuffer(1, OverflowBlock)
+	require.True(t, b.Push(StartEvent{}))
+
+	done := make(chan bool)
+	go func() {
+		done <- b.Push(ReadyEvent{})
+	}()
+	select {
+	case <-done:
+		t.Fatal("push to full buffer must block")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	e, ok := b.Pop(-1)
+	require.True(t, ok)
+	assert.Equal(t, StartEvent{}, e)
+	assert.True(t, <-done)
+	assert.Equal(t, []DriverEvent{ReadyEvent{}}, popAll(b))
+	assert.Equal(t, EventBufferStats{Capacity: 1, HighWater: 1, Pushed: 2}, b.Stats())
+
+	// close releases blocked producer
+	require.True(t, b.Push(StartEvent{}))
+	go func() {
+		done <- b.Push(ReadyEvent{})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b.Close()
+	assert.False(t, <-done)
+	assert.Equal(t, []DriverEvent{StartEvent{}}, popAll(b), "remaining events are popped after close")
+	_, ok = b.Pop(-1)
+	assert.False(t, ok)
+}
+
+func TestEventBufferCoalesce(t *testing.T) {
+	k1 := newRouterControl("dev", "K1")
+	k2 := newRouterControl("dev", "K2")
+	b := NewEventBuffer(2, OverflowCoalesce)
+
+	assert.True(t, b.Push(valueEvent(k1, "", "0")))
+	assert.True(t, b.Push(valueEvent(k2, "", "0")))
+	assert.True(t, b.Push(valueEvent(k1, "0", "1")))
+	assert.True(t, b.Push(valueEvent(k1, "1", "2")))
+
+	// batched values are not merged into others
+	batched := valueEvent(k2, "0", "1")
+	batched.BatchId = 1
+	done := make(chan bool)
+	go func() {
+		done <- b.Push(batched)
+	}()
+	select {
+	case <-done:
+		t.Fatal("batched value must wait for free space")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	assert.Equal(t, valueEvent(k1, "", "2"), mustPop(t, b))
+	assert.True(t, <-done)
+	assert.Equal(t, []DriverEvent{valueEvent(k2, "", "0"), batched}, popAll(b))
+	assert.Equal(t, EventBufferStats{Capacity: 2, HighWater: 2, Pushed: 3, Coalesced: 2}, b.Stats())
+}
+
+func mustPop(t *testing.T, b *EventBuffer) DriverEvent {
+	e, ok := b.Pop(time.Second)
+	require.True(t, ok)
+	return e
+}
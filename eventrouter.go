@@ -0,0 +1,264 @@
+package wbgong
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// EventPattern selects devices and controls by IDs.
+// Pattern has form "device/control" or "device"; each part may be
+// an exact ID, MQTT wildcard ('+' - any ID, '#' - anything below)
+// or glob (see path.Match, e.g. "wb-gpio*" or "K[1-4]").
+// Pattern without control part matches all controls of device
+type EventPattern struct {
+	device  string
+	control string
+}
+
+// ParseEventPattern parses and validates pattern
+func ParseEventPattern(pattern string) (EventPattern, error) {
+	parts := strings.Split(pattern, "/")
+	if len(parts) > 2 || parts[0] == "" {
+		return EventPattern{}, fmt.Errorf("%w: '%s'", IncorrectPatternError, pattern)
+	}
+	p := EventPattern{device: parts[0], control: "#"}
+	if len(parts) == 2 {
+		if parts[1] == "" {
+			return EventPattern{}, fmt.Errorf("%w: empty control in '%s'", IncorrectPatternError, pattern)
+		}
+		p.control = parts[1]
+	}
+	if p.device == "#" {
+		if len(parts) == 2 {
+			return EventPattern{}, fmt.Errorf("%w: '#' must be last in '%s'", IncorrectPatternError, pattern)
+		}
+		p.device = CONV_SUBTOPIC_ALL
+	}
+	for _, part := range []string{p.device, p.control} {
+		if _, err := path.Match(part, ""); err != nil {
+			return EventPattern{}, fmt.Errorf("%w: '%s': %v", IncorrectPatternError, pattern, err)
+		}
+	}
+	return p, nil
+}
+
+// IsExactDevice checks whether device part has no wildcards
+func (p EventPattern) IsExactDevice() bool {
+	return !isWildcardPattern(p.device)
+}
+
+func isWildcardPattern(s string) bool {
+	return s == CONV_SUBTOPIC_ALL || s == "#" || strings.ContainsAny(s, "*?[\\")
+}
+
+func matchPatternPart(pattern, id string) bool {
+	if pattern == CONV_SUBTOPIC_ALL || pattern == "#" {
+		return true
+	}
+	ok, _ := path.Match(pattern, id)
+	return ok
+}
+
+// MatchDevice checks whether device ID matches pattern
+func (p EventPattern) MatchDevice(deviceID string) bool {
+	return matchPatternPart(p.device, deviceID)
+}
+
+// Match checks whether device/control pair matches pattern
+func (p EventPattern) Match(deviceID, controlID string) bool {
+	return p.MatchDevice(deviceID) && matchPatternPart(p.control, controlID)
+}
+
+func (p EventPattern) String() string {
+	return p.device + "/" + p.control
+}
+
+type eventSubscription struct {
+	id       HandlerID
+	pattern  EventPattern
+	metaType string
+	handler  func(e DriverEvent)
+}
+
+// EventRouter dispatches driver events to handlers subscribed by device/control patterns.
+// Subscriptions with exact device IDs are indexed, so dispatch cost doesn't grow
+// with number of subscriptions to other devices
+type EventRouter struct {
+	mu       sync.Mutex
+	allocID  func() HandlerID
+	subs     map[HandlerID]*eventSubscription
+	kinds    map[HandlerID]string
+	byDevice map[string]map[string][]*eventSubscription // kind -> device ID -> subscriptions
+	wildcard map[string][]*eventSubscription            // kind -> subscriptions
+}
+
+// event kinds used as index keys
+const (
+	routerKindValue       = "value"
+	routerKindBatch       = "batch"
+	routerKindOn          = "on"
+	routerKindNewDevice   = "new_device"
+	routerKindDeviceMeta  = "device_meta"
+	routerKindControlMeta = "control_meta"
+)
+
+// NewEventRouter creates empty router. HandlerIDs are allocated by allocID,
+// so driver passes its own allocator and router subscriptions share ID space
+// with OnDriverEvent handlers
+func NewEventRouter(allocID func() HandlerID) *EventRouter {
+	return &EventRouter{
+		allocID:  allocID,
+		subs:     make(map[HandlerID]*eventSubscription),
+		kinds:    make(map[HandlerID]string),
+		byDevice: make(map[string]map[string][]*eventSubscription),
+		wildcard: make(map[string][]*eventSubscription),
+	}
+}
+
+func (r *EventRouter) add(kind, pattern, metaType string, handler func(e DriverEvent)) (HandlerID, error) {
+	p, err := ParseEventPattern(pattern)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sub := &eventSubscription{
+		id:       r.allocID(),
+		pattern:  p,
+		metaType: metaType,
+		handler:  handler,
+	}
+	r.subs[sub.id] = sub
+	r.kinds[sub.id] = kind
+	if p.IsExactDevice() {
+		if r.byDevice[kind] == nil {
+			r.byDevice[kind] = make(map[string][]*eventSubscription)
+		}
+		r.byDevice[kind][p.device] = append(r.byDevice[kind][p.device], sub)
+	} else {
+		r.wildcard[kind] = append(r.wildcard[kind], sub)
+	}
+	return sub.id, nil
+}
+
+// OnControlValue subscribes to ControlValueEvent of controls matching pattern
+func (r *EventRouter) OnControlValue(pattern string, handler func(e ControlValueEvent)) (HandlerID, error) {
+	return r.add(routerKindValue, pattern, "", func(e DriverEvent) {
+		handler(e.(ControlValueEvent))
+	})
+}
+
+// OnControlBatch subscribes to ControlBatchEvent of devices matching pattern
+// (control part of pattern is ignored). Batched values are delivered
+// to OnControlValue subscribers too, as separate ControlValueEvents with non-zero BatchId
+// follow batch event
+func (r *EventRouter) OnControlBatch(pattern string, handler func(e ControlBatchEvent)) (HandlerID, error) {
+	return r.add(routerKindBatch, pattern, "", func(e DriverEvent) {
+		handler(e.(ControlBatchEvent))
+	})
+}
+
+// OnControlOn subscribes to ControlOnValueEvent of controls matching pattern
+func (r *EventRouter) OnControlOn(pattern string, handler func(e ControlOnValueEvent)) (HandlerID, error) {
+	return r.add(routerKindOn, pattern, "", func(e DriverEvent) {
+		handler(e.(ControlOnValueEvent))
+	})
+}
+
+// OnNewExternalDevice subscribes to NewExternalDeviceEvent of devices matching pattern
+// (control part of pattern is ignored)
+func (r *EventRouter) OnNewExternalDevice(pattern string, handler func(e NewExternalDeviceEvent)) (HandlerID, error) {
+	return r.add(routerKindNewDevice, pattern, "", func(e DriverEvent) {
+		handler(e.(NewExternalDeviceEvent))
+	})
+}
+
+// OnMeta subscribes to meta events of given type (any type if empty).
+// Pattern "device" subscribes to NewExternalDeviceMetaEvent,
+// "device/control" - to NewExternalDeviceControlMetaEvent
+func (r *EventRouter) OnMeta(pattern, metaType string, handler func(e DriverEvent)) (HandlerID, error) {
+	kind := routerKindDeviceMeta
+	if strings.Contains(pattern, "/") {
+		kind = routerKindControlMeta
+	}
+	return r.add(kind, pattern, metaType, handler)
+}
+
+// Remove removes subscription, it returns false if there is no subscription with given ID
+func (r *EventRouter) Remove(id HandlerID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sub, ok := r.subs[id]
+	if !ok {
+		return false
+	}
+	kind := r.kinds[id]
+	delete(r.subs, id)
+	delete(r.kinds, id)
+
+	removeSub := func(list []*eventSubscription) []*eventSubscription {
+		for i, s := range list {
+			if s == sub {
+				return append(list[:i:i], list[i+1:]...)
+			}
+		}
+		return list
+	}
+	if sub.pattern.IsExactDevice() {
+		list := removeSub(r.byDevice[kind][sub.pattern.device])
+		if len(list) == 0 {
+			delete(r.byDevice[kind], sub.pattern.device)
+		} else {
+			r.byDevice[kind][sub.pattern.device] = list
+		}
+	} else {
+		r.wildcard[kind] = removeSub(r.wildcard[kind])
+	}
+	return true
+}
+
+// Dispatch delivers event to all matching subscribers
+func (r *EventRouter) Dispatch(e DriverEvent) {
+	var kind, deviceID, controlID, metaType string
+	matchControl := true
+	switch ev := e.(type) {
+	case ControlValueEvent:
+		kind, deviceID, controlID = routerKindValue, ev.Control.GetDevice().GetId(), ev.Control.GetId()
+	case ControlBatchEvent:
+		kind, deviceID, matchControl = routerKindBatch, ev.Device.GetId(), false
+	case ControlOnValueEvent:
+		kind, deviceID, controlID = routerKindOn, ev.Control.GetDevice().GetId(), ev.Control.GetId()
+	case NewExternalDeviceEvent:
+		kind, deviceID, matchControl = routerKindNewDevice, ev.Device.GetId(), false
+	case NewExternalDeviceMetaEvent:
+		kind, deviceID, metaType, matchControl = routerKindDeviceMeta, ev.Device.GetId(), ev.Type, false
+	case NewExternalDeviceControlMetaEvent:
+		kind, deviceID, controlID, metaType = routerKindControlMeta, ev.Control.GetDevice().GetId(), ev.Control.GetId(), ev.Type
+	default:
+		return
+	}
+
+	r.mu.Lock()
+	candidates := make([]*eventSubscription, 0, len(r.byDevice[kind][deviceID])+len(r.wildcard[kind]))
+	candidates = append(candidates, r.byDevice[kind][deviceID]...)
+	candidates = append(candidates, r.wildcard[kind]...)
+	r.mu.Unlock()
+
+	for _, sub := range candidates {
+		if !sub.pattern.MatchDevice(deviceID) {
+			continue
+		}
+		if matchControl && !matchPatternPart(sub.pattern.control, controlID) {
+			continue
+		}
+		if sub.metaType != "" && sub.metaType != metaType {
+			continue
+		}
+		sub.handler(e)
+	}
+}
@@ -0,0 +1,154 @@
+package wbgong
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type routerDevice struct {
+	Device
+	id string
+}
+
+func (d *routerDevice) GetId() string { return d.id }
+
+type routerLocalDevice struct {
+	LocalDevice
+	id string
+}
+
+func (d *routerLocalDevice) GetId() string { return d.id }
+
+type routerControl struct {
+	Control
+	dev Device
+	id  string
+}
+
+func (c *routerControl) GetId() string     { return c.id }
+func (c *routerControl) GetDevice() Device { return c.dev }
+
+func newRouterControl(devID, ctrlID string) *routerControl {
+	return &routerControl{dev: &routerDevice{id: devID}, id: ctrlID}
+}
+
+func TestParseEventPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		parsed  string
+		exact   bool
+		fail    bool
+	}{
+		{pattern: "wb-gpio", parsed: "wb-gpio/#", exact: true},
+		{pattern: "wb-gpio/K1", parsed: "wb-gpio/K1", exact: true},
+		{pattern: "+/K1", parsed: "+/K1"},
+		{pattern: "#", parsed: "+/#"},
+		{pattern: "wb-*/K[1-4]", parsed: "wb-*/K[1-4]"},
+		{pattern: "", fail: true},
+		{pattern: "/K1", fail: true},
+		{pattern: "dev/", fail: true},
+		{pattern: "a/b/c", fail: true},
+		{pattern: "#/K1", fail: true},
+		{pattern: "dev[", fail: true},
+		{pattern: "dev/K[", fail: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.pattern, func(t *testing.T) {
+			p, err := ParseEventPattern(tc.pattern)
+			if tc.fail {
+				assert.ErrorIs(t, err, IncorrectPatternError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.parsed, p.String())
+			assert.Equal(t, tc.exact, p.IsExactDevice())
+		})
+	}
+}
+
+func TestEventPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		device  string
+		control string
+		match   bool
+	}{
+		{"wb-gpio", "wb-gpio", "K1", true},
+		{"wb-gpio", "wb-gpio2", "K1", false},
+		{"wb-gpio/K1", "wb-gpio", "K1", true},
+		{"wb-gpio/K1", "wb-gpio", "K10", false},
+		{"+/K1", "any", "K1", true},
+		{"+/+", "any", "any", true},
+		{"#", "any", "any", true},
+		{"wb-*/K?", "wb-mr6c", "K5", true},
+		{"wb-*/K?", "wb-mr6c", "K10", false},
+		{"wb-*", "gpio", "K1", false},
+		{"dev/K[1-4]", "dev", "K4", true},
+		{"dev/K[1-4]", "dev", "K5", false},
+		{`dev/a\*`, "dev", "a*", true},
+		{`dev/a\*`, "dev", "ab", false},
+	}
+	for _, tc := range tests {
+		p, err := ParseEventPattern(tc.pattern)
+		require.NoError(t, err)
+		assert.Equal(t, tc.match, p.Match(tc.device, tc.control), "%s vs %s/%s", tc.pattern, tc.device, tc.control)
+	}
+}
+
+func TestEventRouterDispatch(t *testing.T) {
+	var next HandlerID
+	r := NewEventRouter(func() HandlerID {
+		next++
+		return next
+	})
+
+	var got []string
+	record := func(name string) func(e ControlValueEvent) {
+		return func(e ControlValueEvent) {
+			got = append(got, name+":"+e.Control.GetDevice().GetId()+"/"+e.Control.GetId())
+		}
+	}
+	exact, err := r.OnControlValue("dev/K1", record("exact"))
+	require.NoError(t, err)
+	_, err = r.OnControlValue("dev", record("device"))
+	require.NoError(t, err)
+	_, err = r.OnControlValue("+/K?", record("wildcard"))
+	require.NoError(t, err)
+	_, err = r.OnControlOn("dev/K1", func(e ControlOnValueEvent) {
+		got = append(got, "on:"+e.RawValue)
+	})
+	require.NoError(t, err)
+	_, err = r.OnMeta("dev/K1", CONV_META_SUBTOPIC_UNITS, func(e DriverEvent) {
+		got = append(got, "meta:"+fmt.Sprint(e.(NewExternalDeviceControlMetaEvent).Value))
+	})
+	require.NoError(t, err)
+	_, err = r.OnControlBatch("d*/ignored", func(e ControlBatchEvent) {
+		got = append(got, "batch:"+e.Device.GetId())
+	})
+	require.NoError(t, err)
+
+	r.Dispatch(ControlValueEvent{Control: newRouterControl("dev", "K1")})
+	r.Dispatch(ControlValueEvent{Control: newRouterControl("dev", "K10")})
+	r.Dispatch(ControlValueEvent{Control: newRouterControl("other", "K2")})
+	r.Dispatch(ControlOnValueEvent{Control: newRouterControl("dev", "K1"), RawValue: "1"})
+	r.Dispatch(NewExternalDeviceControlMetaEvent{Control: newRouterControl("dev", "K1"), Type: CONV_META_SUBTOPIC_TYPE, Value: "x"})
+	r.Dispatch(NewExternalDeviceControlMetaEvent{Control: newRouterControl("dev", "K1"), Type: CONV_META_SUBTOPIC_UNITS, Value: "W"})
+	r.Dispatch(ControlBatchEvent{Device: &routerLocalDevice{id: "dev"}})
+	assert.Equal(t, []string{
+		"exact:dev/K1", "device:dev/K1", "wildcard:dev/K1",
+		"device:dev/K10",
+		"wildcard:other/K2",
+		"on:1",
+		"meta:W",
+		"batch:dev",
+	}, got)
+
+	got = nil
+	assert.True(t, r.Remove(exact))
+	assert.False(t, r.Remove(exact))
+	r.Dispatch(ControlValueEvent{Control: newRouterControl("dev", "K1")})
+	assert.Equal(t, []string{"device:dev/K1", "wildcard:dev/K1"}, got)
+}
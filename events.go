@@ -1,6 +1,9 @@
 package wbgong
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // StartEvent represents start event
 type StartEvent struct{}
@@ -47,9 +50,33 @@ func (e NewExternalDeviceControlEvent) String() string {
 	return fmt.Sprintf("NewExternalDeviceControlEvent{Device:%s,Control:%s}", e.Device.GetId(), e.Control.GetId())
 }
 
+// ExternalDeviceRemovedEvent external device is gone: all its retained topics
+// are cleared or it doesn't match driver filter anymore.
+// Driver removes device when it receives this event
+type ExternalDeviceRemovedEvent struct {
+	Device ExternalDevice
+}
+
+func (e ExternalDeviceRemovedEvent) String() string {
+	return fmt.Sprintf("ExternalDeviceRemovedEvent{Device:%s}", e.Device.GetId())
+}
+
+// ExternalDeviceControlRemovedEvent external control is gone: its value and meta
+// are cleared or it doesn't match driver filter anymore.
+// Driver removes control from device when it receives this event
+type ExternalDeviceControlRemovedEvent struct {
+	Device  ExternalDevice
+	Control Control
+}
+
+func (e ExternalDeviceControlRemovedEvent) String() string {
+	return fmt.Sprintf("ExternalDeviceControlRemovedEvent{Device:%s,Control:%s}", e.Device.GetId(), e.Control.GetId())
+}
+
 // NewExternalDeviceMetaEvent a new external device meta received
 // Fires when driver receives message with topic matching to some
-// external device's meta
+// external device's meta. Value is in legacy form whatever meta format
+// is used by device, it's empty if meta key is removed
 type NewExternalDeviceMetaEvent struct {
 	Device ExternalDevice
 	Type   string
@@ -61,6 +88,8 @@ func (e NewExternalDeviceMetaEvent) String() string {
 }
 
 // NewExternalDeviceControlMetaEvent a new external device control metadata received
+// Custom meta keys from meta v2 JSON are delivered with this event too (see ExtractExtraMeta).
+// Value is in meta v2 JSON form (legacy subtopics are converted), it's nil if meta key is removed
 type NewExternalDeviceControlMetaEvent struct {
 	Control   Control
 	Type      string
@@ -75,11 +104,13 @@ func (e NewExternalDeviceControlMetaEvent) String() string {
 
 // ControlValueEvent a new device value received
 // Device may be either local or external. For local controls
-// this event ignored by driver (sent for user handlers only)
+// this event ignored by driver (sent for user handlers only).
+// BatchId is non-zero if value was changed by ControlBatch, it matches ControlBatchEvent.Id
 type ControlValueEvent struct {
 	Control      Control
 	RawValue     string
 	PrevRawValue string
+	BatchId      uint64
 }
 
 func (e ControlValueEvent) String() string {
@@ -87,6 +118,25 @@ func (e ControlValueEvent) String() string {
 		e.Control.GetId(), e.PrevRawValue, e.RawValue)
 }
 
+// ControlBatchEvent several controls of one local device changed at once
+// Sent for user handlers only, followed by ControlValueEvent with BatchId equal to Id
+// for every changed control (see ControlBatch.Events)
+type ControlBatchEvent struct {
+	Id     uint64
+	Device LocalDevice
+	Values []ControlValueEvent
+	Metas  []ControlMetaUpdate
+}
+
+func (e ControlBatchEvent) String() string {
+	values := make([]string, len(e.Values))
+	for i, v := range e.Values {
+		values[i] = fmt.Sprintf("%s:%s->%s", v.Control.GetId(), v.PrevRawValue, v.RawValue)
+	}
+	return fmt.Sprintf("ControlBatchEvent{Device:%s,Values:[%s],Metas:%d}", e.Device.GetId(),
+		strings.Join(values, ","), len(e.Metas))
+}
+
 // ControlOnValueEvent control received 'on' value
 // Valid for local devices only
 type ControlOnValueEvent struct {
@@ -98,3 +148,29 @@ func (e ControlOnValueEvent) String() string {
 	return fmt.Sprintf("ControlOnValueEvent{Device:%s,Control:%s,Value:%s}", e.Control.GetDevice().GetId(),
 		e.Control.GetId(), e.RawValue)
 }
+
+// ControlOnValueRejectedEvent control 'on' value rejected by interceptor
+// Valid for local devices only
+type ControlOnValueRejectedEvent struct {
+	Control  Control
+	RawValue string
+	Err      ControlError
+}
+
+func (e ControlOnValueRejectedEvent) String() string {
+	return fmt.Sprintf("ControlOnValueRejectedEvent{Device:%s,Control:%s,Value:%s,Error:%s}",
+		e.Control.GetDevice().GetId(), e.Control.GetId(), e.RawValue, e.Err.Error())
+}
+
+// DeviceAvailabilityEvent device availability changed
+// Fires for external devices when device meta or driver availability changes
+type DeviceAvailabilityEvent struct {
+	Device           ExternalDevice
+	Availability     DeviceAvailability
+	PrevAvailability DeviceAvailability
+}
+
+func (e DeviceAvailabilityEvent) String() string {
+	return fmt.Sprintf("DeviceAvailabilityEvent{Device:%s,Availability:%s->%s}", e.Device.GetId(),
+		e.PrevAvailability, e.Availability)
+}
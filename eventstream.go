@@ -0,0 +1,98 @@
+package wbgong
+
+import "sync"
+
+const defaultEventStreamBufferSize = 256
+
+// EventStreamOptions configures EventStream
+type EventStreamOptions struct {
+	// Buffer size, 256 if not set
+	BufferSize int
+
+	// Overflow policy for slow consumers, OverflowDropOldest if not set.
+	// Note that OverflowBlock (and OverflowCoalesce for non-value events)
+	// stall driver loop until consumer catches up
+	Overflow OverflowPolicy
+
+	// Filter selects events to stream, all events are streamed if nil.
+	// Filter runs in driver loop
+	Filter func(e DriverEvent) bool
+}
+
+// EventStream delivers driver events to channel, so they can be processed
+// in consumer's goroutine without stalling driver loop.
+// Every stream has its own buffer, see EventStreamOptions
+type EventStream struct {
+	driver    Driver
+	handlerID HandlerID
+	filter    func(e DriverEvent) bool
+	buf       *EventBuffer
+	ch        chan DriverEvent
+	closedCh  chan struct{}
+	closeOnce sync.Once
+}
+
+// NewEventStream creates stream and subscribes it to driver events
+func NewEventStream(d Driver, opts EventStreamOptions) *EventStream {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultEventStreamBufferSize
+	}
+	s := &EventStream{
+		driver:   d,
+		filter:   opts.Filter,
+		buf:      NewEventBuffer(opts.BufferSize, opts.Overflow),
+		ch:       make(chan DriverEvent),
+		closedCh: make(chan struct{}),
+	}
+	s.handlerID = d.OnDriverEvent(s.push)
+	go s.pump()
+	return s
+}
+
+func (s *EventStream) push(e DriverEvent) {
+	if s.filter != nil && !s.filter(e) {
+		return
+	}
+	s.buf.Push(e)
+}
+
+func (s *EventStream) pump() {
+	defer close(s.ch)
+	for {
+		e, ok := s.buf.Pop(-1)
+		if !ok {
+			return
+		}
+		select {
+		case s.ch <- e:
+		case <-s.closedCh:
+			return
+		}
+	}
+}
+
+// C returns events channel, it's closed after stream is closed.
+// Stream doesn't wait for consumer after Close, so buffered events
+// which are not read by that time may be dropped
+func (s *EventStream) C() <-chan DriverEvent {
+	return s.ch
+}
+
+// Dropped returns number of events dropped due to buffer overflow
+func (s *EventStream) Dropped() uint64 {
+	return s.buf.Stats().Dropped
+}
+
+// Stats returns stream buffer counters
+func (s *EventStream) Stats() EventBufferStats {
+	return s.buf.Stats()
+}
+
+// Close unsubscribes stream from driver events
+func (s *EventStream) Close() {
+	s.driver.RemoveOnDriverEventHandler(s.handlerID)
+	s.buf.Close()
+	s.closeOnce.Do(func() {
+		close(s.closedCh)
+	})
+}
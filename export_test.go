@@ -0,0 +1,11 @@
+package wbgong
+
+import "testing"
+
+// SetTestControlArgs replaces plugin NewControlArgs in external tests
+func SetTestControlArgs(t *testing.T, f func() ControlArgs) {
+	funcNewControlArgs = f
+	t.Cleanup(func() {
+		funcNewControlArgs = nil
+	})
+}
@@ -0,0 +1,524 @@
+// Expressions are used by computed controls to derive control value
+// from values of other controls.
+//
+// Supported syntax:
+//   - float numbers, true and false;
+//   - variables (computed control sources aliases);
+//   - arithmetic operators: + - * / %;
+//   - comparison operators: < <= > >= == !=;
+//   - logical operators: && || !;
+//   - conditional operator: cond ? a : b;
+//   - functions: min(a, b, ...), max(a, b, ...), avg(a, b, ...), abs(x), round(x[, digits]).
+//
+// Boolean values are treated as 1 and 0 in arithmetic.
+
+package wbgong
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Expr is a compiled expression
+type Expr struct {
+	source string
+	root   exprNode
+	vars   []string
+}
+
+type exprNode interface {
+	eval(vars map[string]any) (any, error)
+}
+
+// CompileExpr parses expression
+func CompileExpr(source string) (*Expr, error) {
+	p := &exprParser{src: source}
+	p.next()
+	root, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected '%s'", p.tok.text)
+	}
+	return &Expr{source: source, root: root, vars: p.varsOrder}, nil
+}
+
+// String returns expression source
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Vars returns names of all variables used in expression
+func (e *Expr) Vars() []string {
+	return e.vars
+}
+
+// Eval evaluates expression with given variables values.
+// Variables may be float64, int, bool or strings with numbers
+func (e *Expr) Eval(vars map[string]any) (any, error) {
+	return e.root.eval(vars)
+}
+
+type exprTokenKind int
+
+const (
+	tokEOF exprTokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	src       string
+	pos       int
+	tok       exprToken
+	vars      map[string]bool
+	varsOrder []string
+}
+
+func (p *exprParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s at position %d in '%s'",
+		ExprSyntaxError, fmt.Sprintf(format, args...), p.tok.pos+1, p.src)
+}
+
+var exprOperators = []string{"&&", "||", "<=", ">=", "==", "!=", "+", "-", "*", "/", "%", "<", ">", "!", "(", ")", ",", "?", ":"}
+
+// peek decodes rune at current position
+func (p *exprParser) peek() (rune, int) {
+	return utf8.DecodeRuneInString(p.src[p.pos:])
+}
+
+func isExprIdentRune(r rune, first bool) bool {
+	return r == '_' || unicode.IsLetter(r) || !first && unicode.IsDigit(r)
+}
+
+func (p *exprParser) next() {
+	for p.pos < len(p.src) {
+		r, size := p.peek()
+		if !unicode.IsSpace(r) {
+			break
+		}
+		p.pos += size
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = exprToken{tokEOF, "", start}
+		return
+	}
+	c := p.src[p.pos]
+	r, size := p.peek()
+	switch {
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.' ||
+			p.src[p.pos] == 'e' || p.src[p.pos] == 'E' ||
+			(p.src[p.pos] == '-' || p.src[p.pos] == '+') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E')) {
+			p.pos++
+		}
+		p.tok = exprToken{tokNumber, p.src[start:p.pos], start}
+	case isExprIdentRune(r, true):
+		for p.pos < len(p.src) {
+			r, size := p.peek()
+			if !isExprIdentRune(r, false) {
+				break
+			}
+			p.pos += size
+		}
+		p.tok = exprToken{tokIdent, p.src[start:p.pos], start}
+	default:
+		for _, op := range exprOperators {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				p.pos += len(op)
+				p.tok = exprToken{tokOp, op, start}
+				return
+			}
+		}
+		p.pos += size
+		p.tok = exprToken{tokOp, p.src[start:p.pos], start}
+	}
+}
+
+func (p *exprParser) isOp(ops ...string) bool {
+	if p.tok.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if p.tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.isOp(op) {
+		return p.errorf("'%s' expected", op)
+	}
+	p.next()
+	return nil
+}
+
+func (p *exprParser) parseTernary() (exprNode, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil || !p.isOp("?") {
+		return cond, err
+	}
+	p.next()
+	a, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	b, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return &exprTernary{cond, a, b}, nil
+}
+
+// binary operators by precedence, lowest first
+var exprBinaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) parseBinary(level int) (exprNode, error) {
+	if level == len(exprBinaryLevels) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(exprBinaryLevels[level]...) {
+		op := p.tok.text
+		p.next()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.isOp("-", "!") {
+		op := p.tok.text
+		p.next()
+		arg, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprUnary{op, arg}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("bad number '%s'", tok.text)
+		}
+		p.next()
+		return exprConst{f}, nil
+	case tokIdent:
+		p.next()
+		switch tok.text {
+		case "true":
+			return exprConst{true}, nil
+		case "false":
+			return exprConst{false}, nil
+		}
+		if p.isOp("(") {
+			return p.parseCall(tok)
+		}
+		if p.vars == nil {
+			p.vars = make(map[string]bool)
+		}
+		if !p.vars[tok.text] {
+			p.vars[tok.text] = true
+			p.varsOrder = append(p.varsOrder, tok.text)
+		}
+		return exprVar(tok.text), nil
+	case tokOp:
+		if tok.text == "(" {
+			p.next()
+			node, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		}
+	}
+	if tok.kind == tokEOF {
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return nil, p.errorf("unexpected '%s'", tok.text)
+}
+
+func (p *exprParser) parseCall(name exprToken) (exprNode, error) {
+	fn, ok := exprFuncs[name.text]
+	if !ok {
+		p.tok = name
+		return nil, p.errorf("unknown function '%s'", name.text)
+	}
+	p.next()
+	args := make([]exprNode, 0)
+	for !p.isOp(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		p.tok = name
+		return nil, p.errorf("wrong number of arguments for '%s'", name.text)
+	}
+	return &exprCall{name.text, fn.fn, args}, nil
+}
+
+type exprConst struct {
+	value any
+}
+
+func (c exprConst) eval(vars map[string]any) (any, error) {
+	return c.value, nil
+}
+
+type exprVar string
+
+func (v exprVar) eval(vars map[string]any) (any, error) {
+	value, ok := vars[string(v)]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ExprUnknownVariableError, string(v))
+	}
+	switch x := value.(type) {
+	case float64, bool:
+		return x, nil
+	default:
+		return exprToFloat(x)
+	}
+}
+
+type exprUnary struct {
+	op  string
+	arg exprNode
+}
+
+func (u *exprUnary) eval(vars map[string]any) (any, error) {
+	v, err := u.arg.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if u.op == "!" {
+		return !exprToBool(v), nil
+	}
+	f, err := exprToFloat(v)
+	return -f, err
+}
+
+type exprBinary struct {
+	op          string
+	left, right exprNode
+}
+
+func (b *exprBinary) eval(vars map[string]any) (any, error) {
+	l, err := b.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	// short-circuit logical operators
+	switch b.op {
+	case "&&":
+		if !exprToBool(l) {
+			return false, nil
+		}
+	case "||":
+		if exprToBool(l) {
+			return true, nil
+		}
+	}
+	r, err := b.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if b.op == "&&" || b.op == "||" {
+		return exprToBool(r), nil
+	}
+
+	lf, err := exprToFloat(l)
+	if err != nil {
+		return nil, err
+	}
+	rf, err := exprToFloat(r)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, ExprDivisionByZeroError
+		}
+		return lf / rf, nil
+	case "%":
+		if rf == 0 {
+			return nil, ExprDivisionByZeroError
+		}
+		return math.Mod(lf, rf), nil
+	case "<":
+		return lf < rf, nil
+	case "<=":
+		return lf <= rf, nil
+	case ">":
+		return lf > rf, nil
+	case ">=":
+		return lf >= rf, nil
+	case "==":
+		return lf == rf, nil
+	case "!=":
+		return lf != rf, nil
+	}
+	return nil, fmt.Errorf("%w: unknown operator '%s'", ExprSyntaxError, b.op)
+}
+
+type exprTernary struct {
+	cond, a, b exprNode
+}
+
+func (t *exprTernary) eval(vars map[string]any) (any, error) {
+	c, err := t.cond.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if exprToBool(c) {
+		return t.a.eval(vars)
+	}
+	return t.b.eval(vars)
+}
+
+type exprFunc struct {
+	minArgs int
+	maxArgs int // -1 for unlimited
+	fn      func(args []float64) (any, error)
+}
+
+var exprFuncs = map[string]exprFunc{
+	"min": {1, -1, func(args []float64) (any, error) {
+		res := args[0]
+		for _, a := range args[1:] {
+			res = math.Min(res, a)
+		}
+		return res, nil
+	}},
+	"max": {1, -1, func(args []float64) (any, error) {
+		res := args[0]
+		for _, a := range args[1:] {
+			res = math.Max(res, a)
+		}
+		return res, nil
+	}},
+	"avg": {1, -1, func(args []float64) (any, error) {
+		sum := 0.0
+		for _, a := range args {
+			sum += a
+		}
+		return sum / float64(len(args)), nil
+	}},
+	"abs": {1, 1, func(args []float64) (any, error) {
+		return math.Abs(args[0]), nil
+	}},
+	"round": {1, 2, func(args []float64) (any, error) {
+		if len(args) == 1 {
+			return math.Round(args[0]), nil
+		}
+		k := math.Pow(10, math.Round(args[1]))
+		return math.Round(args[0]*k) / k, nil
+	}},
+}
+
+type exprCall struct {
+	name string
+	fn   func(args []float64) (any, error)
+	args []exprNode
+}
+
+func (c *exprCall) eval(vars map[string]any) (any, error) {
+	args := make([]float64, len(c.args))
+	for i, a := range c.args {
+		v, err := a.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		if args[i], err = exprToFloat(v); err != nil {
+			return nil, err
+		}
+	}
+	return c.fn(args)
+}
+
+func exprToFloat(v any) (float64, error) {
+	switch x := v.(type) {
+	case float64:
+		return x, nil
+	case bool:
+		if x {
+			return 1, nil
+		}
+		return 0, nil
+	case int:
+		return float64(x), nil
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: '%s' is not a number", WrongValueTypeError, x)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("%w: %T", WrongValueTypeError, v)
+	}
+}
+
+func exprToBool(v any) bool {
+	switch x := v.(type) {
+	case bool:
+		return x
+	case float64:
+		return x != 0
+	default:
+		f, err := exprToFloat(v)
+		return err == nil && f != 0
+	}
+}
@@ -0,0 +1,85 @@
+package wbgong
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExprEval(t *testing.T) {
+	vars := map[string]any{
+		"a":    2.0,
+		"b":    3,
+		"on":   true,
+		"s":    "1.5",
+		"темп": 20.0,
+	}
+	tests := []struct {
+		expr   string
+		result any
+	}{
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"a * b", 6.0},
+		{"b % 2", 1.0},
+		{"-a + s", -0.5},
+		{"1e3 / 4", 250.0},
+		{"a < b && on", true},
+		{"a >= b || !on", false},
+		{"a == 2 ? 10 : 20", 10.0},
+		{"on + 1", 2.0},
+		{"min(a, b, 1)", 1.0},
+		{"max(a, b)", 3.0},
+		{"avg(a, b)", 2.5},
+		{"abs(-a)", 2.0},
+		{"round(2.345, 2)", 2.35},
+		{"темп - 1", 19.0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.expr, func(t *testing.T) {
+			e, err := CompileExpr(tc.expr)
+			require.NoError(t, err)
+			res, err := e.Eval(vars)
+			require.NoError(t, err)
+			if b, ok := tc.result.(bool); ok {
+				assert.Equal(t, b, res)
+			} else {
+				assert.InDelta(t, tc.result, res, 1e-9)
+			}
+		})
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  error
+	}{
+		{"1 +", ExprSyntaxError},
+		{"(1 + 2", ExprSyntaxError},
+		{"a ? 1", ExprSyntaxError},
+		{"1 @ 2", ExprSyntaxError},
+		{"1 € 2", ExprSyntaxError},
+		{"unknown(1)", ExprSyntaxError},
+		{"x + 1", ExprUnknownVariableError},
+		{"1 / 0", ExprDivisionByZeroError},
+	}
+	for _, tc := range tests {
+		t.Run(tc.expr, func(t *testing.T) {
+			e, err := CompileExpr(tc.expr)
+			if err == nil {
+				_, err = e.Eval(map[string]any{"a": 1.0})
+			}
+			assert.True(t, errors.Is(err, tc.err), "expected %v, got %v", tc.err, err)
+		})
+	}
+}
+
+func TestExprVars(t *testing.T) {
+	e, err := CompileExpr("max(b, a) + b")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "a"}, e.Vars())
+	assert.Equal(t, "max(b, a) + b", e.String())
+}
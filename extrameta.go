@@ -0,0 +1,64 @@
+package wbgong
+
+import (
+	"fmt"
+	"strings"
+)
+
+// meta keys managed by driver itself, they can't be used as extra meta
+var (
+	standardDeviceMeta = map[string]bool{
+		CONV_META_SUBTOPIC_DRIVER:       true,
+		CONV_META_SUBTOPIC_TITLE:        true,
+		CONV_META_SUBTOPIC_TITLE_V2:     true,
+		CONV_META_SUBTOPIC_ERROR:        true,
+		CONV_META_SUBTOPIC_AVAILABILITY: true,
+	}
+	standardControlMeta = map[string]bool{
+		CONV_META_SUBTOPIC_ERROR:         true,
+		CONV_META_SUBTOPIC_ORDER:         true,
+		CONV_META_SUBTOPIC_TYPE:          true,
+		CONV_META_SUBTOPIC_UNITS:         true,
+		CONV_META_SUBTOPIC_MAX:           true,
+		CONV_META_SUBTOPIC_MIN:           true,
+		CONV_META_SUBTOPIC_PRECISION:     true,
+		CONV_META_SUBTOPIC_DESCRIPTION:   true,
+		CONV_META_SUBTOPIC_CONTROL_TITLE: true,
+		CONV_META_SUBTOPIC_READONLY:      true,
+		CONV_META_SUBTOPIC_CONTROL_ENUM:  true,
+	}
+)
+
+// IsStandardDeviceMeta checks whether key is a conventional device meta key
+func IsStandardDeviceMeta(key string) bool {
+	return standardDeviceMeta[key]
+}
+
+// IsStandardControlMeta checks whether key is a conventional control meta key
+func IsStandardControlMeta(key string) bool {
+	return standardControlMeta[key]
+}
+
+// ValidateExtraMetaKey checks whether key may be used as custom (vendor-specific) meta key
+func ValidateExtraMetaKey(key string, isDevice bool) error {
+	if key == "" || strings.ContainsAny(key, "/+#") {
+		return fmt.Errorf("%w: '%s'", IncorrectMetaKeyError, key)
+	}
+	if (isDevice && IsStandardDeviceMeta(key)) || (!isDevice && IsStandardControlMeta(key)) {
+		return fmt.Errorf("%w: '%s'", ReservedMetaKeyError, key)
+	}
+	return nil
+}
+
+// ExtractExtraMeta returns custom meta keys from device or control meta v2 object.
+// Driver uses it to emit meta events for extra keys received for external devices
+func ExtractExtraMeta(meta MetaInfo, isDevice bool) MetaInfo {
+	extra := make(MetaInfo)
+	for key, value := range meta {
+		if (isDevice && IsStandardDeviceMeta(key)) || (!isDevice && IsStandardControlMeta(key)) {
+			continue
+		}
+		extra[key] = value
+	}
+	return extra
+}
@@ -0,0 +1,272 @@
+package wbgong
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HistoryEntry is a timestamped control value
+type HistoryEntry struct {
+	Time     time.Time
+	RawValue string
+}
+
+// HistoryStats contains statistics of numeric values from control history
+type HistoryStats struct {
+	Count int
+	Min   float64
+	Max   float64
+	Avg   float64
+}
+
+// ControlHistory is a ring buffer of control values.
+// It keeps at most size values not older than window (if window is non-zero).
+// ControlHistory is safe for concurrent use
+type ControlHistory struct {
+	mu      sync.Mutex
+	size    int
+	window  time.Duration
+	entries []HistoryEntry
+	start   int
+	count   int
+	now     func() time.Time
+}
+
+// NewControlHistory creates history buffer with given size and time window.
+// Zero window means that values are only limited by size
+func NewControlHistory(size int, window time.Duration) *ControlHistory {
+	if size < 1 {
+		size = 1
+	}
+	return &ControlHistory{
+		size:    size,
+		window:  window,
+		entries: make([]HistoryEntry, size),
+		now:     time.Now,
+	}
+}
+
+// SetClock replaces time source used to expire values (useful for tests)
+func (h *ControlHistory) SetClock(now func() time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.now = now
+}
+
+// Push adds value to history
+func (h *ControlHistory) Push(t time.Time, rawValue string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.push(t, rawValue)
+}
+
+// PushNow adds value to history with current timestamp
+func (h *ControlHistory) PushNow(rawValue string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.push(h.now(), rawValue)
+}
+
+// push adds value to history, must be called with lock held
+func (h *ControlHistory) push(t time.Time, rawValue string) {
+	end := (h.start + h.count) % h.size
+	h.entries[end] = HistoryEntry{t, rawValue}
+	if h.count < h.size {
+		h.count++
+	} else {
+		h.start = (h.start + 1) % h.size
+	}
+	h.expire()
+}
+
+// expire drops values older than window, must be called with lock held
+func (h *ControlHistory) expire() {
+	if h.window <= 0 {
+		return
+	}
+	border := h.now().Add(-h.window)
+	for h.count > 0 && h.entries[h.start].Time.Before(border) {
+		h.entries[h.start] = HistoryEntry{}
+		h.start = (h.start + 1) % h.size
+		h.count--
+	}
+}
+
+// Len returns number of values in history
+func (h *ControlHistory) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expire()
+	return h.count
+}
+
+// Clear drops all values
+func (h *ControlHistory) Clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = make([]HistoryEntry, h.size)
+	h.start = 0
+	h.count = 0
+}
+
+// LastN returns up to n latest values, oldest first
+func (h *ControlHistory) LastN(n int) []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expire()
+
+	if n > h.count {
+		n = h.count
+	}
+	if n < 0 {
+		n = 0
+	}
+	res := make([]HistoryEntry, n)
+	for i := 0; i < n; i++ {
+		res[i] = h.entries[(h.start+h.count-n+i)%h.size]
+	}
+	return res
+}
+
+// Last returns latest value, false is returned if history is empty
+func (h *ControlHistory) Last() (HistoryEntry, bool) {
+	last := h.LastN(1)
+	if len(last) == 0 {
+		return HistoryEntry{}, false
+	}
+	return last[0], true
+}
+
+// Since returns values with timestamps not before t, oldest first
+func (h *ControlHistory) Since(t time.Time) []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.since(t)
+}
+
+// since returns values with timestamps not before t, must be called with lock held
+func (h *ControlHistory) since(t time.Time) []HistoryEntry {
+	h.expire()
+
+	res := make([]HistoryEntry, 0, h.count)
+	for i := 0; i < h.count; i++ {
+		e := h.entries[(h.start+i)%h.size]
+		if !e.Time.Before(t) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Stats calculates min/max/avg of numeric values not older than d.
+// Non-numeric values are skipped. HistoryEmptyError is returned if there are no values
+func (h *ControlHistory) Stats(d time.Duration) (HistoryStats, error) {
+	stats := HistoryStats{
+		Min: math.Inf(1),
+		Max: math.Inf(-1),
+	}
+	h.mu.Lock()
+	entries := h.since(h.now().Add(-d))
+	h.mu.Unlock()
+
+	sum := 0.0
+	for _, e := range entries {
+		v, err := strconv.ParseFloat(e.RawValue, 64)
+		if err != nil {
+			continue
+		}
+		stats.Count++
+		sum += v
+		stats.Min = math.Min(stats.Min, v)
+		stats.Max = math.Max(stats.Max, v)
+	}
+	if stats.Count == 0 {
+		return HistoryStats{}, HistoryEmptyError
+	}
+	stats.Avg = sum / float64(stats.Count)
+	return stats, nil
+}
+
+// Min returns minimal numeric value over last d
+func (h *ControlHistory) Min(d time.Duration) (float64, error) {
+	stats, err := h.Stats(d)
+	return stats.Min, err
+}
+
+// Max returns maximal numeric value over last d
+func (h *ControlHistory) Max(d time.Duration) (float64, error) {
+	stats, err := h.Stats(d)
+	return stats.Max, err
+}
+
+// Avg returns average of numeric values over last d
+func (h *ControlHistory) Avg(d time.Duration) (float64, error) {
+	stats, err := h.Stats(d)
+	return stats.Avg, err
+}
+
+// HistoryTracker keeps values history for opted-in controls.
+// It listens for ControlValueEvent in driver loop, so histories are
+// up to date inside any DriverTx
+type HistoryTracker struct {
+	mu        sync.Mutex
+	driver    Driver
+	handlerID HandlerID
+	histories map[DeviceControlPair]*ControlHistory
+}
+
+// NewHistoryTracker creates history tracker and attaches it to driver
+func NewHistoryTracker(driver Driver) *HistoryTracker {
+	t := &HistoryTracker{
+		driver:    driver,
+		histories: make(map[DeviceControlPair]*ControlHistory),
+	}
+	t.handlerID = driver.OnDriverEvent(t.handleEvent)
+	return t
+}
+
+func (t *HistoryTracker) handleEvent(e DriverEvent) {
+	event, ok := e.(ControlValueEvent)
+	if !ok {
+		return
+	}
+	if h := t.Get(event.Control.GetDevice().GetId(), event.Control.GetId()); h != nil {
+		h.PushNow(event.RawValue)
+	}
+}
+
+// Track enables history for control with given size and time window.
+// If control is already tracked, its history is replaced with new empty one
+func (t *HistoryTracker) Track(deviceID, controlID string, size int, window time.Duration) *ControlHistory {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := NewControlHistory(size, window)
+	t.histories[DeviceControlPair{deviceID, controlID}] = h
+	return h
+}
+
+// Untrack disables history for control
+func (t *HistoryTracker) Untrack(deviceID, controlID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.histories, DeviceControlPair{deviceID, controlID})
+}
+
+// Get returns control history or nil if control is not tracked
+func (t *HistoryTracker) Get(deviceID, controlID string) *ControlHistory {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.histories[DeviceControlPair{deviceID, controlID}]
+}
+
+// GetForControl returns history of given control or nil if control is not tracked
+func (t *HistoryTracker) GetForControl(control Control) *ControlHistory {
+	return t.Get(control.GetDevice().GetId(), control.GetId())
+}
+
+// Close detaches tracker from driver
+func (t *HistoryTracker) Close() {
+	t.driver.RemoveOnDriverEventHandler(t.handlerID)
+}
@@ -0,0 +1,126 @@
+package wbgong
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClock is a manually advanced time source
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func historyValues(entries []HistoryEntry) []string {
+	values := make([]string, len(entries))
+	for i, e := range entries {
+		values[i] = e.RawValue
+	}
+	return values
+}
+
+func TestControlHistoryWraparound(t *testing.T) {
+	h := NewControlHistory(3, 0)
+	start := time.Unix(1000, 0)
+	for i, v := range []string{"1", "2", "3", "4", "5"} {
+		h.Push(start.Add(time.Duration(i)*time.Second), v)
+	}
+	assert.Equal(t, 3, h.Len())
+	assert.Equal(t, []string{"3", "4", "5"}, historyValues(h.LastN(10)))
+	assert.Equal(t, []string{"4", "5"}, historyValues(h.LastN(2)))
+	assert.Empty(t, h.LastN(-1))
+	assert.Equal(t, []string{"4", "5"}, historyValues(h.Since(start.Add(3*time.Second))))
+
+	last, ok := h.Last()
+	assert.True(t, ok)
+	assert.Equal(t, HistoryEntry{start.Add(4 * time.Second), "5"}, last)
+
+	h.Clear()
+	_, ok = h.Last()
+	assert.False(t, ok)
+}
+
+func TestControlHistoryWindow(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	h := NewControlHistory(10, time.Minute)
+	h.SetClock(clock.Now)
+
+	h.PushNow("1")
+	clock.Advance(40 * time.Second)
+	h.PushNow("2")
+	assert.Equal(t, []string{"1", "2"}, historyValues(h.LastN(10)))
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, 1, h.Len())
+	assert.Equal(t, []string{"2"}, historyValues(h.LastN(10)))
+
+	clock.Advance(time.Minute)
+	assert.Zero(t, h.Len())
+}
+
+func TestControlHistoryStats(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	h := NewControlHistory(10, 0)
+	h.SetClock(clock.Now)
+
+	_, err := h.Stats(time.Minute)
+	assert.ErrorIs(t, err, HistoryEmptyError)
+
+	h.PushNow("text")
+	_, err = h.Stats(time.Minute)
+	assert.ErrorIs(t, err, HistoryEmptyError)
+
+	h.PushNow("10")
+	clock.Advance(30 * time.Second)
+	h.PushNow("2")
+	h.PushNow("")
+	h.PushNow("6")
+
+	stats, err := h.Stats(time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, HistoryStats{Count: 3, Min: 2, Max: 10, Avg: 6}, stats)
+
+	// older values are out of range
+	v, err := h.Max(10 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 6.0, v)
+	v, err = h.Avg(10 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 4.0, v)
+}
+
+func TestControlHistoryConcurrentClock(t *testing.T) {
+	h := NewControlHistory(10, time.Minute)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			h.SetClock(time.Now)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			h.PushNow("1")
+			h.Stats(time.Minute)
+		}
+	}()
+	wg.Wait()
+}
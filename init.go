@@ -9,6 +9,11 @@ import (
 var plug *plugin.Plugin
 
 // Init tries to load shared library
+//
+// Plugin implements interfaces declared here (Driver, DriverTx, devices and controls),
+// which change between wbgong revisions, so it must be built against
+// the same wbgong revision as the application. Go runtime refuses to open
+// plugin built against different version of package, error is returned then
 func Init(path string) (err error) {
 	plug, err = plugin.Open(path)
 	if err != nil {
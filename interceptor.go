@@ -0,0 +1,151 @@
+package wbgong
+
+import (
+	"sync"
+	"time"
+)
+
+// OnValueAction is an action chosen by OnValueInterceptor
+type OnValueAction int
+
+const (
+	// Pass value further (possibly rewritten)
+	OnValueAccept OnValueAction = iota
+	// Drop value, driver republishes current control value and sets error
+	OnValueReject
+	// Pass value further after delay
+	OnValueDelay
+)
+
+// OnValueDecision is a result of OnValueInterceptor.
+// RawValue replaces incoming value only if Modified is set,
+// so zero OnValueDecision accepts value as is
+type OnValueDecision struct {
+	Action   OnValueAction
+	RawValue string
+	Modified bool
+	Delay    time.Duration
+	Err      ControlError
+}
+
+// AcceptOnValue returns decision to pass given (possibly rewritten) value
+func AcceptOnValue(rawValue string) OnValueDecision {
+	return OnValueDecision{Action: OnValueAccept, RawValue: rawValue, Modified: true}
+}
+
+// RejectOnValue returns decision to drop value with given reason
+func RejectOnValue(err ControlError) OnValueDecision {
+	return OnValueDecision{Action: OnValueReject, Err: err}
+}
+
+// DelayOnValue returns decision to pass given value after delay
+func DelayOnValue(rawValue string, d time.Duration) OnValueDecision {
+	return OnValueDecision{Action: OnValueDelay, RawValue: rawValue, Modified: true, Delay: d}
+}
+
+// OnValueInterceptor is called for every '/on' value received by local control
+// before ControlOnValueEvent is delivered.
+// Interceptors run sync with DriverFrontend, so don't block here; use OnValueDelay instead
+type OnValueInterceptor func(control Control, rawValue string, tx DriverTx) OnValueDecision
+
+type onValueInterceptorItem struct {
+	id HandlerID
+	f  OnValueInterceptor
+}
+
+// OnValueInterceptorChain is an ordered list of interceptors.
+// Driver keeps one chain for all controls and every local control has its own chain
+type OnValueInterceptorChain struct {
+	mu     sync.Mutex
+	nextID HandlerID
+	items  []onValueInterceptorItem
+}
+
+// Add appends interceptor to the end of chain
+func (c *OnValueInterceptorChain) Add(f OnValueInterceptor) HandlerID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	c.items = append(c.items, onValueInterceptorItem{c.nextID, f})
+	return c.nextID
+}
+
+// Remove removes interceptor by its ID
+func (c *OnValueInterceptorChain) Remove(id HandlerID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, item := range c.items {
+		if item.id == id {
+			c.items = append(c.items[:i:i], c.items[i+1:]...)
+			return
+		}
+	}
+}
+
+// Len returns number of interceptors in chain
+func (c *OnValueInterceptorChain) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+// Run runs all interceptors in order. Every interceptor gets value returned
+// by previous one; first rejection stops the chain; delays are summed up.
+// Rejections without error get write error flag (see MetaError)
+func (c *OnValueInterceptorChain) Run(control Control, rawValue string, tx DriverTx) OnValueDecision {
+	if c == nil {
+		return OnValueDecision{Action: OnValueAccept, RawValue: rawValue}
+	}
+	c.mu.Lock()
+	items := append([]onValueInterceptorItem(nil), c.items...)
+	c.mu.Unlock()
+
+	res := OnValueDecision{Action: OnValueAccept, RawValue: rawValue}
+	for _, item := range items {
+		d := item.f(control, res.RawValue, tx)
+		switch d.Action {
+		case OnValueReject:
+			if d.Err == nil {
+				d.Err = NewMetaError(ErrorFlagWrite)
+			}
+			return d
+		case OnValueDelay:
+			res.Action = OnValueDelay
+			res.Delay += d.Delay
+		}
+		if d.Modified {
+			res.RawValue = d.RawValue
+			res.Modified = true
+		}
+	}
+	return res
+}
+
+// RunOnValueInterceptors runs driver-wide chain and then control chain
+func RunOnValueInterceptors(driverChain, controlChain *OnValueInterceptorChain, control Control, rawValue string, tx DriverTx) OnValueDecision {
+	res := driverChain.Run(control, rawValue, tx)
+	if res.Action == OnValueReject {
+		return res
+	}
+	d := controlChain.Run(control, res.RawValue, tx)
+	if d.Action == OnValueReject {
+		return d
+	}
+	d.Modified = d.Modified || res.Modified
+	if res.Action == OnValueDelay {
+		d.Action = OnValueDelay
+		d.Delay += res.Delay
+	}
+	return d
+}
+
+// ReflectRejectedOnValue reflects rejected '/on' value back to MQTT:
+// republishes current control value (so UI switches back) and sets control error.
+// Error is published as meta error flags (see ToMetaError)
+func ReflectRejectedOnValue(control Control, tx DeviceDriverTx, err ControlError) error {
+	raw := control.GetRawValue()
+	if e := tx.UpdateControlValue(control, raw, raw, false)(); e != nil {
+		return e
+	}
+	return control.SetError(ToMetaError(err).ToError())()
+}
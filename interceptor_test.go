@@ -0,0 +1,72 @@
+package wbgong
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnValueInterceptorChain(t *testing.T) {
+	reject := func(err ControlError) OnValueInterceptor {
+		return func(control Control, rawValue string, tx DriverTx) OnValueDecision {
+			return RejectOnValue(err)
+		}
+	}
+	pass := func(control Control, rawValue string, tx DriverTx) OnValueDecision {
+		return OnValueDecision{}
+	}
+	double := func(control Control, rawValue string, tx DriverTx) OnValueDecision {
+		return AcceptOnValue(rawValue + rawValue)
+	}
+	delay := func(control Control, rawValue string, tx DriverTx) OnValueDecision {
+		return DelayOnValue(rawValue, time.Second)
+	}
+	tests := []struct {
+		name     string
+		chain    []OnValueInterceptor
+		decision OnValueDecision
+	}{
+		{"empty", nil, OnValueDecision{RawValue: "1"}},
+		{"pass", []OnValueInterceptor{pass, pass}, OnValueDecision{RawValue: "1"}},
+		{"rewrite", []OnValueInterceptor{double, pass, double}, OnValueDecision{RawValue: "1111", Modified: true}},
+		{"delays sum", []OnValueInterceptor{delay, double, delay},
+			OnValueDecision{Action: OnValueDelay, RawValue: "11", Modified: true, Delay: 2 * time.Second}},
+		{"reject without error", []OnValueInterceptor{double, reject(nil), double},
+			OnValueDecision{Action: OnValueReject, Err: NewMetaError(ErrorFlagWrite)}},
+		{"reject with error", []OnValueInterceptor{reject(NewMetaError(ErrorFlagRead))},
+			OnValueDecision{Action: OnValueReject, Err: NewMetaError(ErrorFlagRead)}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			chain := &OnValueInterceptorChain{}
+			for _, f := range tc.chain {
+				chain.Add(f)
+			}
+			assert.Equal(t, tc.decision, chain.Run(nil, "1", nil))
+		})
+	}
+}
+
+func TestRunOnValueInterceptors(t *testing.T) {
+	driverChain, controlChain := &OnValueInterceptorChain{}, &OnValueInterceptorChain{}
+	id := driverChain.Add(func(control Control, rawValue string, tx DriverTx) OnValueDecision {
+		return DelayOnValue("2", time.Second)
+	})
+	controlChain.Add(func(control Control, rawValue string, tx DriverTx) OnValueDecision {
+		return OnValueDecision{}
+	})
+	assert.Equal(t, OnValueDecision{Action: OnValueDelay, RawValue: "2", Modified: true, Delay: time.Second},
+		RunOnValueInterceptors(driverChain, controlChain, nil, "1", nil))
+
+	driverChain.Remove(id)
+	assert.Zero(t, driverChain.Len())
+	controlChain.Add(func(control Control, rawValue string, tx DriverTx) OnValueDecision {
+		return RejectOnValue(errors.New("limit exceeded"))
+	})
+	d := RunOnValueInterceptors(driverChain, controlChain, nil, "1", nil)
+	assert.Equal(t, OnValueReject, d.Action)
+	// free-text reasons are published as flags
+	assert.Equal(t, NewMetaError(ErrorFlagRead), ToMetaError(d.Err))
+}
@@ -0,0 +1,147 @@
+package wbgong
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MetaErrorFlag is a single flag of /meta/error payload
+type MetaErrorFlag byte
+
+const (
+	ErrorFlagRead       MetaErrorFlag = 'r' // read error
+	ErrorFlagWrite      MetaErrorFlag = 'w' // write error
+	ErrorFlagPeriodMiss MetaErrorFlag = 'p' // poll period miss
+)
+
+// known flags in conventional order
+const metaErrorFlagsOrder = "rwp"
+
+// MetaError is a structured /meta/error value: combination of one-letter flags
+// (e.g. "r", "w", "rp"). Empty MetaError means no error.
+// MetaError implements both ControlError and DeviceError, but empty MetaError
+// stored in error interface is not nil: use ToError to pass it as error
+type MetaError string
+
+// NewMetaError combines given flags, unknown flags are ignored
+func NewMetaError(flags ...MetaErrorFlag) MetaError {
+	return MetaError("").With(flags...)
+}
+
+// ParseMetaError parses /meta/error payload.
+// WrongValueError is returned for payloads which are not combinations of known flags
+// (e.g. free-form error messages)
+func ParseMetaError(payload string) (MetaError, error) {
+	for i := range payload {
+		if strings.IndexByte(metaErrorFlagsOrder, payload[i]) < 0 {
+			return "", fmt.Errorf("%w: '%s' is not a meta error flags set", WrongValueError, payload)
+		}
+	}
+	flags := make([]MetaErrorFlag, len(payload))
+	for i := range payload {
+		flags[i] = MetaErrorFlag(payload[i])
+	}
+	return NewMetaError(flags...), nil
+}
+
+// ToMetaError converts arbitrary control or device error to MetaError.
+// Errors which are not flags combinations are treated as read errors
+func ToMetaError(err interface{ Error() string }) MetaError {
+	if err == nil {
+		return ""
+	}
+	if e, ok := err.(MetaError); ok {
+		return e
+	}
+	e, parseErr := ParseMetaError(err.Error())
+	if parseErr != nil {
+		return NewMetaError(ErrorFlagRead)
+	}
+	return e
+}
+
+// ToError returns e as ControlError or nil if there are no flags
+func (e MetaError) ToError() ControlError {
+	if e.IsEmpty() {
+		return nil
+	}
+	return e
+}
+
+func (e MetaError) Error() string {
+	return string(e)
+}
+
+// String formats /meta/error payload
+func (e MetaError) String() string {
+	return string(e)
+}
+
+// IsEmpty checks whether there are no error flags
+func (e MetaError) IsEmpty() bool {
+	return e == ""
+}
+
+// Has checks whether flag is set
+func (e MetaError) Has(flag MetaErrorFlag) bool {
+	return strings.IndexByte(string(e), byte(flag)) >= 0
+}
+
+// IsReadError checks whether read error flag is set
+func (e MetaError) IsReadError() bool {
+	return e.Has(ErrorFlagRead)
+}
+
+// IsWriteError checks whether write error flag is set
+func (e MetaError) IsWriteError() bool {
+	return e.Has(ErrorFlagWrite)
+}
+
+// IsPeriodMiss checks whether period miss flag is set
+func (e MetaError) IsPeriodMiss() bool {
+	return e.Has(ErrorFlagPeriodMiss)
+}
+
+// With returns error with given flags added, unknown flags are ignored
+func (e MetaError) With(flags ...MetaErrorFlag) MetaError {
+	set := make(map[byte]bool, len(e)+len(flags))
+	for i := range e {
+		set[e[i]] = true
+	}
+	for _, f := range flags {
+		set[byte(f)] = true
+	}
+	return metaErrorFromSet(set)
+}
+
+// Without returns error with given flags removed
+func (e MetaError) Without(flags ...MetaErrorFlag) MetaError {
+	set := make(map[byte]bool, len(e))
+	for i := range e {
+		set[e[i]] = true
+	}
+	for _, f := range flags {
+		delete(set, byte(f))
+	}
+	return metaErrorFromSet(set)
+}
+
+// Combine returns union of flags of both errors
+func (e MetaError) Combine(other MetaError) MetaError {
+	flags := make([]MetaErrorFlag, len(other))
+	for i := range other {
+		flags[i] = MetaErrorFlag(other[i])
+	}
+	return e.With(flags...)
+}
+
+// metaErrorFromSet formats flags in conventional order
+func metaErrorFromSet(set map[byte]bool) MetaError {
+	var b strings.Builder
+	for i := range metaErrorFlagsOrder {
+		if set[metaErrorFlagsOrder[i]] {
+			b.WriteByte(metaErrorFlagsOrder[i])
+		}
+	}
+	return MetaError(b.String())
+}
@@ -0,0 +1,68 @@
+package wbgong
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMetaError(t *testing.T) {
+	tests := []struct {
+		payload string
+		parsed  MetaError
+		fail    bool
+	}{
+		{payload: "", parsed: ""},
+		{payload: "r", parsed: "r"},
+		{payload: "rw", parsed: "rw"},
+		{payload: "pr", parsed: "rp"},
+		{payload: "wrw", parsed: "rw"},
+		{payload: "timeout", fail: true},
+		{payload: "R", fail: true},
+		{payload: "r w", fail: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.payload, func(t *testing.T) {
+			e, err := ParseMetaError(tc.payload)
+			if tc.fail {
+				assert.ErrorIs(t, err, WrongValueError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.parsed, e)
+		})
+	}
+}
+
+func TestToMetaError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  ControlError
+		meta MetaError
+	}{
+		{"nil", nil, ""},
+		{"meta error", NewMetaError(ErrorFlagWrite), "w"},
+		{"flags text", errors.New("pr"), "rp"},
+		{"free text", errors.New("timeout"), "r"},
+		{"upper case", errors.New("R"), "r"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			meta := ToMetaError(tc.err)
+			assert.Equal(t, tc.meta, meta)
+		})
+	}
+}
+
+func TestMetaErrorFlags(t *testing.T) {
+	e := NewMetaError(ErrorFlagPeriodMiss, ErrorFlagRead, MetaErrorFlag('x'))
+	assert.Equal(t, MetaError("rp"), e)
+	assert.True(t, e.IsReadError())
+	assert.False(t, e.IsWriteError())
+	assert.True(t, e.IsPeriodMiss())
+	assert.Equal(t, MetaError("p"), e.Without(ErrorFlagRead))
+	assert.Equal(t, MetaError("rwp"), e.Combine(NewMetaError(ErrorFlagWrite)))
+	assert.Nil(t, e.Without(ErrorFlagRead, ErrorFlagPeriodMiss).ToError())
+	assert.Equal(t, ControlError(e), e.ToError())
+}
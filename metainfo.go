@@ -0,0 +1,343 @@
+package wbgong
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Equal checks whether two MetaInfos are deeply equal.
+// Numbers are compared by value regardless of their Go types,
+// titles and enums are compared by content
+func (m MetaInfo) Equal(other MetaInfo) bool {
+	return metaValuesEqual(map[string]any(m), map[string]any(other))
+}
+
+// Clone returns deep copy of MetaInfo
+func (m MetaInfo) Clone() MetaInfo {
+	if m == nil {
+		return nil
+	}
+	res := make(MetaInfo, len(m))
+	for key, value := range m {
+		res[key] = cloneMetaValue(value)
+	}
+	return res
+}
+
+// Merge returns new MetaInfo with values from other added to m.
+// Nested objects (titles, enums) are merged recursively, other values are replaced
+func (m MetaInfo) Merge(other MetaInfo) MetaInfo {
+	res := m.Clone()
+	if res == nil {
+		res = make(MetaInfo)
+	}
+	for key, value := range other {
+		res[key] = mergeMetaValues(res[key], value, false)
+	}
+	return res
+}
+
+// Patch returns new MetaInfo with RFC 7386 merge patch applied:
+// nil values remove keys, nested objects are patched recursively
+func (m MetaInfo) Patch(patch MetaInfo) MetaInfo {
+	res := m.Clone()
+	if res == nil {
+		res = make(MetaInfo)
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(res, key)
+			continue
+		}
+		res[key] = mergeMetaValues(res[key], value, true)
+	}
+	return res
+}
+
+// MergePatch applies RFC 7386 JSON merge patch document to MetaInfo
+func (m MetaInfo) MergePatch(data []byte) (MetaInfo, error) {
+	patch := make(MetaInfo)
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, fmt.Errorf("%w: bad merge patch: %v", WrongValueError, err)
+	}
+	return m.Patch(patch), nil
+}
+
+// Diff returns RFC 7386 merge patch which turns oldMeta into m.
+// Result is empty if MetaInfos are equal
+func (m MetaInfo) Diff(oldMeta MetaInfo) MetaInfo {
+	return MetaInfo(diffMetaObjects(normalizeMetaObject(map[string]any(oldMeta)),
+		normalizeMetaObject(map[string]any(m))))
+}
+
+// CreateMergePatch returns RFC 7386 JSON merge patch document which turns oldMeta into m
+func (m MetaInfo) CreateMergePatch(oldMeta MetaInfo) ([]byte, error) {
+	return json.Marshal(m.Diff(oldMeta))
+}
+
+func diffMetaObjects(oldObj, newObj map[string]any) map[string]any {
+	patch := make(map[string]any)
+	for key, newValue := range newObj {
+		oldValue, ok := oldObj[key]
+		if !ok {
+			patch[key] = newValue
+			continue
+		}
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		oldMap, oldIsMap := oldValue.(map[string]any)
+		newMap, newIsMap := newValue.(map[string]any)
+		if oldIsMap && newIsMap {
+			patch[key] = diffMetaObjects(oldMap, newMap)
+		} else {
+			patch[key] = newValue
+		}
+	}
+	for key := range oldObj {
+		if _, ok := newObj[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	return patch
+}
+
+func mergeMetaValues(oldValue, newValue any, isPatch bool) any {
+	newMap, newIsMap := toMetaObject(newValue)
+	if !newIsMap {
+		return cloneMetaValue(newValue)
+	}
+	oldMap, oldIsMap := toMetaObject(oldValue)
+	if !oldIsMap {
+		oldMap = make(map[string]any)
+	}
+	res := make(map[string]any, len(oldMap)+len(newMap))
+	for key, value := range oldMap {
+		res[key] = cloneMetaValue(value)
+	}
+	for key, value := range newMap {
+		if isPatch && value == nil {
+			delete(res, key)
+			continue
+		}
+		res[key] = mergeMetaValues(res[key], value, isPatch)
+	}
+	// keep typed values: type of new value wins, generic objects (e.g. from JSON patch)
+	// take type of value they are merged into
+	if _, ok := newValue.(map[string]any); ok {
+		return retypeMetaObject(res, oldValue)
+	}
+	return retypeMetaObject(res, newValue)
+}
+
+// retypeMetaObject converts merged generic map back to the type of like,
+// generic map is returned if like is generic or conversion is not possible
+func retypeMetaObject(obj map[string]any, like any) any {
+	switch like.(type) {
+	case MetaInfo:
+		return MetaInfo(obj)
+	case Title, map[string]string:
+		title, err := metaTitle(obj)
+		if err != nil {
+			return obj
+		}
+		if _, ok := like.(Title); ok {
+			return title
+		}
+		return map[string]string(title)
+	case map[string]Title:
+		enum, err := metaEnum(obj)
+		if err != nil {
+			return obj
+		}
+		return enum
+	default:
+		return obj
+	}
+}
+
+// toMetaObject converts maps used in meta (Title, enums, JSON objects) to generic map
+func toMetaObject(v any) (map[string]any, bool) {
+	switch x := v.(type) {
+	case map[string]any:
+		return x, true
+	case MetaInfo:
+		return map[string]any(x), true
+	case Title:
+		res := make(map[string]any, len(x))
+		for key, value := range x {
+			res[key] = value
+		}
+		return res, true
+	case map[string]string:
+		return toMetaObject(Title(x))
+	case map[string]Title:
+		res := make(map[string]any, len(x))
+		for key, value := range x {
+			res[key], _ = toMetaObject(value)
+		}
+		return res, true
+	default:
+		return nil, false
+	}
+}
+
+// normalizeMetaValue converts value to form produced by JSON decoding
+// (float64 numbers, generic maps and slices) to compare values deeply
+func normalizeMetaValue(v any) any {
+	if obj, ok := toMetaObject(v); ok {
+		return normalizeMetaObject(obj)
+	}
+	switch x := v.(type) {
+	case int:
+		return float64(x)
+	case int32:
+		return float64(x)
+	case int64:
+		return float64(x)
+	case uint:
+		return float64(x)
+	case float32:
+		return float64(x)
+	case json.Number:
+		f, err := x.Float64()
+		if err != nil {
+			return x.String()
+		}
+		return f
+	case []any:
+		res := make([]any, len(x))
+		for i, value := range x {
+			res[i] = normalizeMetaValue(value)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+func normalizeMetaObject(obj map[string]any) map[string]any {
+	res := make(map[string]any, len(obj))
+	for key, value := range obj {
+		res[key] = normalizeMetaValue(value)
+	}
+	return res
+}
+
+func metaValuesEqual(a, b any) bool {
+	return reflect.DeepEqual(normalizeMetaValue(a), normalizeMetaValue(b))
+}
+
+func cloneMetaValue(v any) any {
+	switch x := v.(type) {
+	case map[string]any:
+		res := make(map[string]any, len(x))
+		for key, value := range x {
+			res[key] = cloneMetaValue(value)
+		}
+		return res
+	case MetaInfo:
+		return x.Clone()
+	case Title:
+		res := make(Title, len(x))
+		for key, value := range x {
+			res[key] = value
+		}
+		return res
+	case map[string]Title:
+		res := make(map[string]Title, len(x))
+		for key, value := range x {
+			res[key] = cloneMetaValue(value).(Title)
+		}
+		return res
+	case []any:
+		res := make([]any, len(x))
+		for i, value := range x {
+			res[i] = cloneMetaValue(value)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+func (m MetaInfo) get(key string) (any, error) {
+	value, ok := m[key]
+	if !ok || value == nil {
+		return nil, fmt.Errorf("%w: '%s'", MetaKeyNotFoundError, key)
+	}
+	return value, nil
+}
+
+func wrapMetaTypeError(key string, value any, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: meta '%s' has type %T", WrongValueTypeError, key, value)
+}
+
+// GetString returns string meta value
+func (m MetaInfo) GetString(key string) (string, error) {
+	value, err := m.get(key)
+	if err != nil {
+		return "", err
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", wrapMetaTypeError(key, value, WrongValueTypeError)
+	}
+	return s, nil
+}
+
+// GetFloat returns numeric meta value, numeric strings (legacy meta) are accepted
+func (m MetaInfo) GetFloat(key string) (float64, error) {
+	value, err := m.get(key)
+	if err != nil {
+		return 0, err
+	}
+	f, err := metaFloat(value)
+	return f, wrapMetaTypeError(key, value, err)
+}
+
+// GetInt returns integer meta value (e.g. order)
+func (m MetaInfo) GetInt(key string) (int, error) {
+	f, err := m.GetFloat(key)
+	if err != nil {
+		return 0, err
+	}
+	if f != float64(int(f)) {
+		return 0, fmt.Errorf("%w: meta '%s' is not integer", WrongValueTypeError, key)
+	}
+	return int(f), nil
+}
+
+// GetBool returns boolean meta value, "0"/"1" strings (legacy meta) are accepted
+func (m MetaInfo) GetBool(key string) (bool, error) {
+	value, err := m.get(key)
+	if err != nil {
+		return false, err
+	}
+	b, err := metaBool(value)
+	return b, wrapMetaTypeError(key, value, err)
+}
+
+// GetTitle returns title meta value, plain strings are treated as english titles
+func (m MetaInfo) GetTitle(key string) (Title, error) {
+	value, err := m.get(key)
+	if err != nil {
+		return nil, err
+	}
+	t, err := metaTitle(value)
+	return t, wrapMetaTypeError(key, value, err)
+}
+
+// GetEnumTitles returns enum titles meta value
+func (m MetaInfo) GetEnumTitles(key string) (map[string]Title, error) {
+	value, err := m.get(key)
+	if err != nil {
+		return nil, err
+	}
+	e, err := metaEnum(value)
+	return e, wrapMetaTypeError(key, value, err)
+}
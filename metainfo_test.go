@@ -0,0 +1,91 @@
+package wbgong
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetaInfoMergePatch(t *testing.T) {
+	meta := MetaInfo{
+		"type":     "value",
+		"order":    1,
+		"readonly": true,
+		"title":    Title{"en": "Temp", "ru": "Темп"},
+	}
+	tests := []struct {
+		name   string
+		patch  string
+		result MetaInfo
+	}{
+		{"empty", `{}`, meta},
+		{"replace", `{"order": 2}`, MetaInfo{"type": "value", "order": 2, "readonly": true,
+			"title": Title{"en": "Temp", "ru": "Темп"}}},
+		{"null deletes key", `{"readonly": null, "missing": null}`, MetaInfo{"type": "value", "order": 1,
+			"title": Title{"en": "Temp", "ru": "Темп"}}},
+		{"nested", `{"title": {"ru": null, "de": "Temp"}}`, MetaInfo{"type": "value", "order": 1,
+			"readonly": true, "title": Title{"en": "Temp", "de": "Temp"}}},
+		{"add object", `{"enum": {"0": {"en": "Off"}}}`, MetaInfo{"type": "value", "order": 1,
+			"readonly": true, "title": Title{"en": "Temp", "ru": "Темп"},
+			"enum": map[string]any{"0": map[string]any{"en": "Off"}}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := meta.MergePatch([]byte(tc.patch))
+			require.NoError(t, err)
+			assert.True(t, tc.result.Equal(res), "%v", res)
+			if _, ok := res["title"]; ok {
+				assert.IsType(t, Title{}, res["title"], "title keeps its type")
+			}
+
+			// patch created from result turns meta into result again
+			data, err := res.CreateMergePatch(meta)
+			require.NoError(t, err)
+			again, err := meta.MergePatch(data)
+			require.NoError(t, err)
+			assert.True(t, res.Equal(again), "%s: %v", data, again)
+		})
+	}
+	assert.Equal(t, "Temp", meta["title"].(Title)["en"], "source meta is not modified")
+
+	_, err := meta.MergePatch([]byte(`[]`))
+	assert.ErrorIs(t, err, WrongValueError)
+}
+
+func TestMetaInfoCreateMergePatch(t *testing.T) {
+	oldMeta := MetaInfo{"type": "value", "order": 1, "title": Title{"en": "A", "ru": "А"}, "units": "W"}
+	newMeta := MetaInfo{"type": "value", "order": 1.0, "title": Title{"en": "B", "ru": "А"}, "max": 10}
+	data, err := newMeta.CreateMergePatch(oldMeta)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"title": {"en": "B"}, "units": null, "max": 10}`, string(data))
+
+	data, err = newMeta.CreateMergePatch(newMeta.Clone())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{}`, string(data))
+}
+
+func TestMetaInfoEqual(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  MetaInfo
+		equal bool
+	}{
+		{"int and float", MetaInfo{"order": 1}, MetaInfo{"order": 1.0}, true},
+		{"int64 and float32", MetaInfo{"max": int64(2)}, MetaInfo{"max": float32(2)}, true},
+		{"json number", MetaInfo{"min": json.Number("0.5")}, MetaInfo{"min": 0.5}, true},
+		{"different numbers", MetaInfo{"order": 1}, MetaInfo{"order": 2}, false},
+		{"number and string", MetaInfo{"order": 1}, MetaInfo{"order": "1"}, false},
+		{"title types", MetaInfo{"title": Title{"en": "A"}}, MetaInfo{"title": map[string]any{"en": "A"}}, true},
+		{"enum types", MetaInfo{"enum": map[string]Title{"0": {"en": "Off"}}},
+			MetaInfo{"enum": map[string]any{"0": map[string]any{"en": "Off"}}}, true},
+		{"missing key", MetaInfo{"a": "1"}, MetaInfo{}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.equal, tc.a.Equal(tc.b))
+			assert.Equal(t, tc.equal, tc.b.Equal(tc.a))
+		})
+	}
+}
@@ -0,0 +1,452 @@
+// Meta migration helpers allow to convert legacy meta subtopics
+// (/devices/+/meta/+, /devices/+/controls/+/meta/+) to meta v2 JSON
+// (/devices/+/meta, /devices/+/controls/+/meta) and back.
+
+package wbgong
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetaPublishMode selects meta formats published by driver
+type MetaPublishMode int
+
+const (
+	// Publish meta v2 JSON only (default)
+	CONV_META_MODE_V2 MetaPublishMode = iota
+	// Publish legacy meta subtopics only
+	CONV_META_MODE_LEGACY
+	// Publish both legacy meta subtopics and meta v2 JSON,
+	// used during transition period until all consumers are upgraded
+	CONV_META_MODE_BOTH
+)
+
+func (m MetaPublishMode) String() string {
+	switch m {
+	case CONV_META_MODE_V2:
+		return "v2"
+	case CONV_META_MODE_LEGACY:
+		return "legacy"
+	case CONV_META_MODE_BOTH:
+		return "both"
+	default:
+		return fmt.Sprintf("MetaPublishMode(%d)", int(m))
+	}
+}
+
+// PublishLegacy checks whether legacy meta subtopics must be published in this mode
+func (m MetaPublishMode) PublishLegacy() bool {
+	return m == CONV_META_MODE_LEGACY || m == CONV_META_MODE_BOTH
+}
+
+// PublishV2 checks whether meta v2 JSON must be published in this mode
+func (m MetaPublishMode) PublishV2() bool {
+	return m == CONV_META_MODE_V2 || m == CONV_META_MODE_BOTH
+}
+
+// legacy control meta subtopics which carry non-string values in meta v2
+var legacyControlFloatMeta = map[string]bool{
+	CONV_META_SUBTOPIC_MAX:       true,
+	CONV_META_SUBTOPIC_MIN:       true,
+	CONV_META_SUBTOPIC_PRECISION: true,
+}
+
+// LegacyDeviceMetaToJson converts legacy device meta subtopics to meta v2 JSON object.
+// If both 'name' and 'title' are present, 'title' wins. Live 'error' subtopic is skipped
+func LegacyDeviceMetaToJson(legacy map[string]string) (MetaInfo, error) {
+	meta := make(MetaInfo)
+	for key, value := range legacy {
+		if value == "" || key == CONV_META_SUBTOPIC_ERROR {
+			continue
+		}
+		switch key {
+		case CONV_META_SUBTOPIC_TITLE, CONV_META_SUBTOPIC_TITLE_V2:
+			if key == CONV_META_SUBTOPIC_TITLE && legacy[CONV_META_SUBTOPIC_TITLE_V2] != "" {
+				continue
+			}
+			title, err := parseLegacyTitle(value)
+			if err != nil {
+				return nil, fmt.Errorf("device meta %s: %w", key, err)
+			}
+			meta[CONV_META_SUBTOPIC_TITLE_V2] = title
+		default:
+			meta[key] = value
+		}
+	}
+	return meta, nil
+}
+
+// LegacyControlMetaToJson converts legacy control meta subtopics to meta v2 JSON object.
+// Live 'error' subtopic is skipped
+func LegacyControlMetaToJson(legacy map[string]string) (MetaInfo, error) {
+	meta := make(MetaInfo)
+	for key, value := range legacy {
+		if value == "" || key == CONV_META_SUBTOPIC_ERROR {
+			continue
+		}
+		switch {
+		case key == CONV_META_SUBTOPIC_CONTROL_TITLE:
+			title, err := parseLegacyTitle(value)
+			if err != nil {
+				return nil, fmt.Errorf("control meta %s: %w", key, err)
+			}
+			meta[key] = title
+		case key == CONV_META_SUBTOPIC_READONLY:
+			meta[key] = value == CONV_META_BOOL_TRUE || value == "true"
+		case key == CONV_META_SUBTOPIC_ORDER:
+			order, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("control meta %s: %w", key, err)
+			}
+			meta[key] = order
+		case legacyControlFloatMeta[key]:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("control meta %s: %w", key, err)
+			}
+			meta[key] = f
+		case key == CONV_META_SUBTOPIC_CONTROL_ENUM:
+			enum := make(map[string]Title)
+			if err := json.Unmarshal([]byte(value), &enum); err != nil {
+				return nil, fmt.Errorf("control meta %s: %w", key, err)
+			}
+			meta[key] = enum
+		default:
+			meta[key] = value
+		}
+	}
+	return meta, nil
+}
+
+// MetaJsonToLegacy converts meta v2 JSON object to legacy meta subtopics payloads.
+// Device titles are published as legacy 'name' subtopic if isDevice is set
+func MetaJsonToLegacy(meta MetaInfo, isDevice bool) (map[string]string, error) {
+	legacy := make(map[string]string, len(meta))
+	for key, value := range meta {
+		var payload string
+		switch v := value.(type) {
+		case nil:
+			continue
+		case string:
+			payload = v
+		case bool:
+			payload = CONV_META_BOOL_FALSE
+			if v {
+				payload = CONV_META_BOOL_TRUE
+			}
+		case int:
+			payload = strconv.Itoa(v)
+		case float64:
+			payload = strconv.FormatFloat(v, 'f', -1, 64)
+		case Title:
+			payload = legacyTitle(v)
+		case map[string]string:
+			payload = legacyTitle(Title(v))
+		case map[string]any:
+			if key != CONV_META_SUBTOPIC_TITLE_V2 {
+				b, err := json.Marshal(v)
+				if err != nil {
+					return nil, fmt.Errorf("meta %s: %w", key, err)
+				}
+				payload = string(b)
+				break
+			}
+			title := make(Title, len(v))
+			for lang, s := range v {
+				title[lang] = fmt.Sprint(s)
+			}
+			payload = legacyTitle(title)
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("meta %s: %w", key, err)
+			}
+			payload = string(b)
+		}
+		if isDevice && key == CONV_META_SUBTOPIC_TITLE_V2 {
+			key = CONV_META_SUBTOPIC_TITLE
+		}
+		legacy[key] = payload
+	}
+	return legacy, nil
+}
+
+// legacy titles are plain strings; JSON-encoded titles are accepted too
+func parseLegacyTitle(value string) (Title, error) {
+	if strings.HasPrefix(value, "{") {
+		title := make(Title)
+		if err := json.Unmarshal([]byte(value), &title); err != nil {
+			return nil, err
+		}
+		return title, nil
+	}
+	return Title{"en": value}, nil
+}
+
+// legacy consumers expect english title or any title if there is no english one
+func legacyTitle(title Title) string {
+	if s, ok := title["en"]; ok {
+		return s
+	}
+	langs := make([]string, 0, len(title))
+	for lang := range title {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	if len(langs) == 0 {
+		return ""
+	}
+	return title[langs[0]]
+}
+
+// MetaTreeControl contains meta of single control collected from retained messages
+type MetaTreeControl struct {
+	Legacy map[string]string
+	V2     MetaInfo
+}
+
+// MetaTreeDevice contains meta of single device collected from retained messages
+type MetaTreeDevice struct {
+	Legacy   map[string]string
+	V2       MetaInfo
+	Controls map[string]*MetaTreeControl
+}
+
+// MetaTree is a set of device and control meta collected from broker retained messages
+type MetaTree struct {
+	mu      sync.Mutex
+	Devices map[string]*MetaTreeDevice
+}
+
+// NewMetaTree returns new empty MetaTree
+func NewMetaTree() *MetaTree {
+	return &MetaTree{
+		Devices: make(map[string]*MetaTreeDevice),
+	}
+}
+
+// ReadMetaTree collects all retained meta topics from broker.
+// Client must be started. ReadMetaTree returns RetainedTimeoutError
+// if retained messages are not received within timeout
+func ReadMetaTree(client MQTTClient, timeout time.Duration) (*MetaTree, error) {
+	tree := NewMetaTree()
+	topics := []string{
+		fmt.Sprintf(CONV_DEVICE_META_V2_FMT, CONV_SUBTOPIC_ALL),
+		fmt.Sprintf(CONV_DEVICE_META_FMT, CONV_SUBTOPIC_ALL, CONV_SUBTOPIC_ALL),
+		fmt.Sprintf(CONV_CONTROL_META_V2_FMT, CONV_SUBTOPIC_ALL, CONV_SUBTOPIC_ALL),
+		fmt.Sprintf(CONV_CONTROL_ALL_META_FMT, CONV_SUBTOPIC_ALL, CONV_SUBTOPIC_ALL),
+	}
+	client.Subscribe(func(msg MQTTMessage) {
+		if err := tree.AddMessage(msg); err != nil {
+			Warn.Printf("meta migration: skipping %s: %v", msg.Topic, err)
+		}
+	}, topics...)
+	defer client.Unsubscribe(topics...)
+
+	ready := make(chan struct{})
+	client.WaitForRetained(func() {
+		close(ready)
+	})
+
+	select {
+	case <-ready:
+		return tree, nil
+	case <-time.After(timeout):
+		return nil, RetainedTimeoutError
+	}
+}
+
+func (t *MetaTree) device(id string) *MetaTreeDevice {
+	dev, ok := t.Devices[id]
+	if !ok {
+		dev = &MetaTreeDevice{
+			Legacy:   make(map[string]string),
+			Controls: make(map[string]*MetaTreeControl),
+		}
+		t.Devices[id] = dev
+	}
+	return dev
+}
+
+func (dev *MetaTreeDevice) control(id string) *MetaTreeControl {
+	ctrl, ok := dev.Controls[id]
+	if !ok {
+		ctrl = &MetaTreeControl{
+			Legacy: make(map[string]string),
+		}
+		dev.Controls[id] = ctrl
+	}
+	return ctrl
+}
+
+// AddMessage stores meta from MQTT message in tree.
+// Messages with non-meta topics are ignored
+func (t *MetaTree) AddMessage(msg MQTTMessage) error {
+	parts := strings.Split(msg.Topic, "/")
+	// parts[0] is empty because of leading slash
+	if len(parts) < 4 || parts[0] != "" || parts[1] != "devices" {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case len(parts) == 4 && parts[3] == "meta":
+		return t.device(parts[2]).setV2(msg.Payload)
+	case len(parts) == 5 && parts[3] == "meta":
+		t.device(parts[2]).Legacy[parts[4]] = msg.Payload
+	case len(parts) == 6 && parts[3] == "controls" && parts[5] == "meta":
+		return t.device(parts[2]).control(parts[4]).setV2(msg.Payload)
+	case len(parts) == 7 && parts[3] == "controls" && parts[5] == "meta":
+		t.device(parts[2]).control(parts[4]).Legacy[parts[6]] = msg.Payload
+	}
+	return nil
+}
+
+func parseMetaJson(payload string) (MetaInfo, error) {
+	if payload == "" {
+		return nil, nil
+	}
+	meta := make(MetaInfo)
+	if err := json.Unmarshal([]byte(payload), &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (dev *MetaTreeDevice) setV2(payload string) (err error) {
+	dev.V2, err = parseMetaJson(payload)
+	return
+}
+
+func (ctrl *MetaTreeControl) setV2(payload string) (err error) {
+	ctrl.V2, err = parseMetaJson(payload)
+	return
+}
+
+// Migrate returns retained meta v2 messages for all devices and controls
+// which have legacy meta but no meta v2 yet.
+// If overwrite is set, existing meta v2 is replaced by converted legacy meta
+func (t *MetaTree) Migrate(overwrite bool) ([]MQTTMessage, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	msgs := make([]MQTTMessage, 0)
+	for _, devID := range sortedKeys(t.Devices) {
+		dev := t.Devices[devID]
+		if len(dev.Legacy) > 0 && (dev.V2 == nil || overwrite) {
+			meta, err := LegacyDeviceMetaToJson(dev.Legacy)
+			if err != nil {
+				return nil, fmt.Errorf("device %s: %w", devID, err)
+			}
+			msg, err := metaJsonMessage(fmt.Sprintf(CONV_DEVICE_META_V2_FMT, devID), meta)
+			if err != nil {
+				return nil, fmt.Errorf("device %s: %w", devID, err)
+			}
+			msgs = append(msgs, msg)
+		}
+
+		for _, ctrlID := range sortedKeys(dev.Controls) {
+			ctrl := dev.Controls[ctrlID]
+			if len(ctrl.Legacy) == 0 || (ctrl.V2 != nil && !overwrite) {
+				continue
+			}
+			meta, err := LegacyControlMetaToJson(ctrl.Legacy)
+			if err != nil {
+				return nil, fmt.Errorf("control %s/%s: %w", devID, ctrlID, err)
+			}
+			msg, err := metaJsonMessage(fmt.Sprintf(CONV_CONTROL_META_V2_FMT, devID, ctrlID), meta)
+			if err != nil {
+				return nil, fmt.Errorf("control %s/%s: %w", devID, ctrlID, err)
+			}
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+// liveMetaSubtopics are published in addition to meta v2 and must not be cleared
+var liveMetaSubtopics = map[string]bool{
+	CONV_META_SUBTOPIC_ERROR:  true,
+	CONV_META_SUBTOPIC_DRIVER: true,
+}
+
+// LegacyCleanup returns messages which clear legacy meta retained topics
+// of devices and controls which already have meta v2.
+// Live subtopics (error, driver) are kept.
+// It's used to stop dual-publishing when all consumers are upgraded
+func (t *MetaTree) LegacyCleanup() []MQTTMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	msgs := make([]MQTTMessage, 0)
+	for _, devID := range sortedKeys(t.Devices) {
+		dev := t.Devices[devID]
+		if dev.V2 != nil {
+			for _, key := range sortedKeys(dev.Legacy) {
+				if liveMetaSubtopics[key] {
+					continue
+				}
+				msgs = append(msgs, clearRetainedMessage(fmt.Sprintf(CONV_DEVICE_META_FMT, devID, key)))
+			}
+		}
+		for _, ctrlID := range sortedKeys(dev.Controls) {
+			ctrl := dev.Controls[ctrlID]
+			if ctrl.V2 == nil {
+				continue
+			}
+			for _, key := range sortedKeys(ctrl.Legacy) {
+				if liveMetaSubtopics[key] {
+					continue
+				}
+				msgs = append(msgs, clearRetainedMessage(fmt.Sprintf(CONV_CONTROL_META_FMT, devID, ctrlID, key)))
+			}
+		}
+	}
+	return msgs
+}
+
+// PublishMessages publishes all given messages with PublishSynced
+func PublishMessages(client MQTTClient, msgs []MQTTMessage) {
+	for _, msg := range msgs {
+		client.PublishSynced(msg)
+	}
+}
+
+func metaJsonMessage(topic string, meta MetaInfo) (MQTTMessage, error) {
+	payload, err := json.Marshal(meta)
+	if err != nil {
+		return MQTTMessage{}, err
+	}
+	return MQTTMessage{
+		Topic:    topic,
+		Payload:  string(payload),
+		QoS:      1,
+		Retained: true,
+	}, nil
+}
+
+func clearRetainedMessage(topic string) MQTTMessage {
+	return MQTTMessage{
+		Topic:    topic,
+		Payload:  "",
+		QoS:      1,
+		Retained: true,
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,142 @@
+package wbgong
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLegacyDeviceMetaToJson(t *testing.T) {
+	tests := []struct {
+		name   string
+		legacy map[string]string
+		meta   MetaInfo
+	}{
+		{"name", map[string]string{"name": "Dev", "driver": "wb-rules"},
+			MetaInfo{"title": Title{"en": "Dev"}, "driver": "wb-rules"}},
+		{"title wins over name", map[string]string{"name": "Old", "title": `{"en":"New","ru":"Новое"}`},
+			MetaInfo{"title": Title{"en": "New", "ru": "Новое"}}},
+		{"empty title", map[string]string{"name": "Dev", "title": ""},
+			MetaInfo{"title": Title{"en": "Dev"}}},
+		{"error is live", map[string]string{"name": "Dev", "error": "r"},
+			MetaInfo{"title": Title{"en": "Dev"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// map iteration order varies, so conversion is repeated
+			for i := 0; i < 20; i++ {
+				meta, err := LegacyDeviceMetaToJson(tc.legacy)
+				require.NoError(t, err)
+				assert.Equal(t, tc.meta, meta)
+			}
+		})
+	}
+}
+
+func TestLegacyControlMetaToJson(t *testing.T) {
+	tests := []struct {
+		name   string
+		legacy map[string]string
+		meta   MetaInfo
+		fail   bool
+	}{
+		{name: "types", legacy: map[string]string{
+			"type": "range", "readonly": "1", "order": "3", "max": "100", "min": "-1.5", "precision": "0.1",
+		}, meta: MetaInfo{
+			"type": "range", "readonly": true, "order": 3, "max": 100.0, "min": -1.5, "precision": 0.1,
+		}},
+		{name: "readonly false", legacy: map[string]string{"readonly": "0"}, meta: MetaInfo{"readonly": false}},
+		{name: "title and enum", legacy: map[string]string{"title": "Temp", "enum": `{"1":{"en":"On"}}`},
+			meta: MetaInfo{"title": Title{"en": "Temp"}, "enum": map[string]Title{"1": {"en": "On"}}}},
+		{name: "error is live", legacy: map[string]string{"type": "value", "error": "r"},
+			meta: MetaInfo{"type": "value"}},
+		{name: "bad order", legacy: map[string]string{"order": "x"}, fail: true},
+		{name: "bad max", legacy: map[string]string{"max": "x"}, fail: true},
+		{name: "bad enum", legacy: map[string]string{"enum": "{"}, fail: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			meta, err := LegacyControlMetaToJson(tc.legacy)
+			if tc.fail {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.meta, meta)
+		})
+	}
+}
+
+func TestMetaJsonToLegacy(t *testing.T) {
+	legacy, err := MetaJsonToLegacy(MetaInfo{
+		"title":    map[string]any{"ru": "Имя", "en": "Name"},
+		"readonly": true,
+		"order":    2,
+		"max":      10.5,
+		"units":    "W",
+		"enum":     map[string]any{"1": map[string]any{"en": "On"}},
+		"skipped":  nil,
+	}, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"title":    "Name",
+		"readonly": "1",
+		"order":    "2",
+		"max":      "10.5",
+		"units":    "W",
+		"enum":     `{"1":{"en":"On"}}`,
+	}, legacy)
+
+	legacy, err = MetaJsonToLegacy(MetaInfo{"title": Title{"ru": "Имя"}}, true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "Имя"}, legacy)
+}
+
+func metaTreeOf(t *testing.T, msgs map[string]string) *MetaTree {
+	tree := NewMetaTree()
+	for topic, payload := range msgs {
+		require.NoError(t, tree.AddMessage(MQTTMessage{Topic: topic, Payload: payload, Retained: true}))
+	}
+	return tree
+}
+
+func TestMetaTreeMigrate(t *testing.T) {
+	tree := metaTreeOf(t, map[string]string{
+		"/devices/a/meta/name":               "A",
+		"/devices/a/meta/error":              "r",
+		"/devices/a/controls/x/meta/type":    "switch",
+		"/devices/a/controls/x/meta/error":   "r",
+		"/devices/a/controls/y/meta/type":    "value",
+		"/devices/a/controls/y/meta":         `{"type":"text"}`,
+		"/devices/b/meta":                    `{"title":{"en":"B"}}`,
+		"/devices/b/meta/name":               "B",
+		"/devices/b/controls/z/meta/order":   "1",
+		"/devices/b/controls/z/meta/unknown": "u",
+		"/other/topic":                       "ignored",
+	})
+
+	msgs, err := tree.Migrate(false)
+	require.NoError(t, err)
+	assert.Equal(t, []MQTTMessage{
+		{Topic: "/devices/a/meta", Payload: `{"title":{"en":"A"}}`, QoS: 1, Retained: true},
+		{Topic: "/devices/a/controls/x/meta", Payload: `{"type":"switch"}`, QoS: 1, Retained: true},
+		{Topic: "/devices/b/controls/z/meta", Payload: `{"order":1,"unknown":"u"}`, QoS: 1, Retained: true},
+	}, msgs)
+
+	msgs, err = tree.Migrate(true)
+	require.NoError(t, err)
+	assert.Len(t, msgs, 5)
+
+	assert.Equal(t, []MQTTMessage{
+		clearRetainedMessage("/devices/a/controls/y/meta/type"),
+		clearRetainedMessage("/devices/b/meta/name"),
+	}, tree.LegacyCleanup())
+}
+
+func TestMetaTreeBadJson(t *testing.T) {
+	tree := NewMetaTree()
+	assert.Error(t, tree.AddMessage(MQTTMessage{Topic: "/devices/a/meta", Payload: "{"}))
+	assert.NoError(t, tree.AddMessage(MQTTMessage{Topic: "/devices/a/meta", Payload: ""}))
+	assert.Nil(t, tree.Devices["a"].V2)
+}
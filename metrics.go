@@ -0,0 +1,326 @@
+package wbgong
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricSummary accumulates durations (in seconds)
+type metricSummary struct {
+	count uint64
+	sum   float64
+	max   float64
+}
+
+func (s *metricSummary) observe(d time.Duration) {
+	v := d.Seconds()
+	s.count++
+	s.sum += v
+	if v > s.max {
+		s.max = v
+	}
+}
+
+// DriverMetrics collects driver internals metrics and exposes them
+// in Prometheus text exposition format.
+// Events are counted by driver event handler; MQTT traffic is counted
+// by clients wrapped with WrapMQTTClient; Access wait time is measured
+// by DriverMetrics.Access
+type DriverMetrics struct {
+	mu             sync.Mutex
+	driver         Driver
+	handlerID      HandlerID
+	events         map[string]uint64
+	handlers       map[string]*metricSummary
+	published      uint64
+	received       uint64
+	subscribed     uint64
+	accessWait     metricSummary
+	accessDuration metricSummary
+	collectErrors  uint64
+	// device counts are collected in driver loop in background,
+	// last collected counts are exposed
+	counts     *deviceCounts
+	collecting bool
+}
+
+// NewDriverMetrics creates metrics collector and attaches it to driver
+func NewDriverMetrics(d Driver) *DriverMetrics {
+	m := &DriverMetrics{
+		driver:   d,
+		events:   make(map[string]uint64),
+		handlers: make(map[string]*metricSummary),
+	}
+	m.handlerID = d.OnDriverEvent(m.countEvent)
+	return m
+}
+
+// Close detaches collector from driver
+func (m *DriverMetrics) Close() {
+	m.driver.RemoveOnDriverEventHandler(m.handlerID)
+}
+
+func eventTypeName(e DriverEvent) string {
+	name := fmt.Sprintf("%T", e)
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func (m *DriverMetrics) countEvent(e DriverEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events[eventTypeName(e)]++
+}
+
+// InstrumentHandler wraps driver event handler measuring its latency under given name
+func (m *DriverMetrics) InstrumentHandler(name string, handler func(e DriverEvent)) func(e DriverEvent) {
+	return func(e DriverEvent) {
+		start := time.Now()
+		handler(e)
+		m.observeHandler(name, time.Since(start))
+	}
+}
+
+func (m *DriverMetrics) observeHandler(name string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.handlers[name]
+	if !ok {
+		s = &metricSummary{}
+		m.handlers[name] = s
+	}
+	s.observe(d)
+}
+
+// Access works like Driver.Access measuring time spent waiting for driver loop
+// and time spent in thunk
+func (m *DriverMetrics) Access(thunk func(tx DriverTx) error) error {
+	requested := time.Now()
+	var started time.Time
+	err := m.driver.Access(func(tx DriverTx) error {
+		started = time.Now()
+		m.mu.Lock()
+		m.accessWait.observe(started.Sub(requested))
+		m.mu.Unlock()
+		return thunk(tx)
+	})
+	if !started.IsZero() {
+		m.mu.Lock()
+		m.accessDuration.observe(time.Since(started))
+		m.mu.Unlock()
+	}
+	return err
+}
+
+type metricsMQTTClient struct {
+	MQTTClient
+	metrics *DriverMetrics
+}
+
+// WrapMQTTClient returns client which counts published, received messages and subscriptions
+func (m *DriverMetrics) WrapMQTTClient(client MQTTClient) MQTTClient {
+	return &metricsMQTTClient{client, m}
+}
+
+func (c *metricsMQTTClient) count(counter *uint64) {
+	c.metrics.mu.Lock()
+	*counter++
+	c.metrics.mu.Unlock()
+}
+
+func (c *metricsMQTTClient) Publish(message MQTTMessage) {
+	c.count(&c.metrics.published)
+	c.MQTTClient.Publish(message)
+}
+
+func (c *metricsMQTTClient) PublishSynced(message MQTTMessage) {
+	c.count(&c.metrics.published)
+	c.MQTTClient.PublishSynced(message)
+}
+
+func (c *metricsMQTTClient) Subscribe(callback MQTTMessageHandler, topics ...string) {
+	c.metrics.mu.Lock()
+	c.metrics.subscribed += uint64(len(topics))
+	c.metrics.mu.Unlock()
+	c.MQTTClient.Subscribe(func(message MQTTMessage) {
+		c.count(&c.metrics.received)
+		callback(message)
+	}, topics...)
+}
+
+type deviceCounts struct {
+	localDevices     int
+	externalDevices  int
+	localControls    int
+	externalControls int
+}
+
+// refreshDevices schedules device counts collection in driver loop,
+// so metrics writers never wait for driver loop
+func (m *DriverMetrics) refreshDevices() {
+	m.mu.Lock()
+	if m.collecting {
+		m.mu.Unlock()
+		return
+	}
+	m.collecting = true
+	m.mu.Unlock()
+
+	var counts deviceCounts
+	future := m.driver.AccessAsync(func(tx DriverTx) error {
+		for _, dev := range tx.GetDevicesList() {
+			n := len(dev.ControlsList())
+			if _, ok := dev.(LocalDevice); ok {
+				counts.localDevices++
+				counts.localControls += n
+			} else {
+				counts.externalDevices++
+				counts.externalControls += n
+			}
+		}
+		return nil
+	})
+	go func() {
+		err := future()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.collecting = false
+		if err != nil {
+			m.collectErrors++
+			return
+		}
+		m.counts = &counts
+	}()
+}
+
+type promWriter struct {
+	w      *bufio.Writer
+	labels string
+}
+
+func (p *promWriter) header(name, kind, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (p *promWriter) value(name string, v any, labels ...string) {
+	all := p.labels
+	for i := 0; i+1 < len(labels); i += 2 {
+		all += fmt.Sprintf(",%s=\"%s\"", labels[i], promEscape(labels[i+1]))
+	}
+	fmt.Fprintf(p.w, "%s{%s} %v\n", name, all, v)
+}
+
+func (p *promWriter) summary(name string, s metricSummary, labels ...string) {
+	p.value(name+"_count", s.count, labels...)
+	p.value(name+"_sum", s.sum, labels...)
+}
+
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// WritePrometheus writes all metrics in Prometheus text exposition format.
+// Device counts are collected in driver loop in background, so they're
+// as of previous collection and omitted until first collection is done
+func (m *DriverMetrics) WritePrometheus(w io.Writer) error {
+	m.refreshDevices()
+	stats := m.driver.Stats()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := &promWriter{
+		w:      bufio.NewWriter(w),
+		labels: fmt.Sprintf("driver=\"%s\"", promEscape(m.driver.GetId())),
+	}
+
+	if counts := m.counts; counts != nil {
+		p.header("wbgong_devices", "gauge", "Number of devices known to driver")
+		p.value("wbgong_devices", counts.localDevices, "kind", "local")
+		p.value("wbgong_devices", counts.externalDevices, "kind", "external")
+		p.header("wbgong_controls", "gauge", "Number of controls known to driver")
+		p.value("wbgong_controls", counts.localControls, "kind", "local")
+		p.value("wbgong_controls", counts.externalControls, "kind", "external")
+	}
+	p.header("wbgong_metrics_collect_errors_total", "counter", "Failed device counts collections")
+	p.value("wbgong_metrics_collect_errors_total", m.collectErrors)
+
+	p.header("wbgong_events_total", "counter", "Driver events processed by type")
+	for _, name := range sortedKeys(m.events) {
+		p.value("wbgong_events_total", m.events[name], "type", name)
+	}
+
+	p.header("wbgong_event_queue_length", "gauge", "Current event queue length")
+	p.value("wbgong_event_queue_length", stats.Queue.Len)
+	p.header("wbgong_event_queue_capacity", "gauge", "Event queue capacity")
+	p.value("wbgong_event_queue_capacity", stats.Queue.Capacity)
+	p.header("wbgong_event_queue_high_water", "gauge", "Maximal event queue length")
+	p.value("wbgong_event_queue_high_water", stats.Queue.HighWater)
+	p.header("wbgong_event_queue_dropped_total", "counter", "Events dropped due to queue overflow")
+	p.value("wbgong_event_queue_dropped_total", stats.Queue.Dropped)
+	p.header("wbgong_event_queue_coalesced_total", "counter", "Value events merged due to queue overflow")
+	p.value("wbgong_event_queue_coalesced_total", stats.Queue.Coalesced)
+
+	p.header("wbgong_mqtt_published_total", "counter", "MQTT messages published")
+	p.value("wbgong_mqtt_published_total", m.published)
+	p.header("wbgong_mqtt_received_total", "counter", "MQTT messages received")
+	p.value("wbgong_mqtt_received_total", m.received)
+	p.header("wbgong_mqtt_subscriptions_total", "counter", "MQTT topics subscribed")
+	p.value("wbgong_mqtt_subscriptions_total", m.subscribed)
+
+	p.header("wbgong_access_wait_seconds", "summary", "Time spent waiting for driver loop in Access")
+	p.summary("wbgong_access_wait_seconds", m.accessWait)
+	p.header("wbgong_access_duration_seconds", "summary", "Time spent in Access thunks")
+	p.summary("wbgong_access_duration_seconds", m.accessDuration)
+	p.header("wbgong_access_wait_max_seconds", "gauge", "Maximal time spent waiting for driver loop in Access")
+	p.value("wbgong_access_wait_max_seconds", m.accessWait.max)
+	p.header("wbgong_access_duration_max_seconds", "gauge", "Maximal time spent in Access thunks")
+	p.value("wbgong_access_duration_max_seconds", m.accessDuration.max)
+
+	if len(m.handlers) > 0 {
+		p.header("wbgong_handler_duration_seconds", "summary", "Event handlers latency")
+		names := make([]string, 0, len(m.handlers))
+		for name := range m.handlers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p.summary("wbgong_handler_duration_seconds", *m.handlers[name], "handler", name)
+		}
+		p.header("wbgong_handler_duration_max_seconds", "gauge", "Maximal event handlers latency")
+		for _, name := range names {
+			p.value("wbgong_handler_duration_max_seconds", m.handlers[name].max, "handler", name)
+		}
+	}
+
+	return p.w.Flush()
+}
+
+// ServeHTTP implements http.Handler, so metrics can be exposed with local HTTP listener
+func (m *DriverMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := m.WritePrometheus(w); err != nil {
+		Error.Printf("failed to write metrics: %v", err)
+	}
+}
+
+// DumpToFile atomically writes metrics to file (e.g. for node_exporter textfile collector)
+func (m *DriverMetrics) DumpToFile(path string) error {
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := WriteFileAtomic(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	return nil
+}
@@ -3,7 +3,8 @@ package wbgong
 import "log"
 
 var (
-	funcNewPahoMQTTClient func(string, string) MQTTClient
+	funcNewPahoMQTTClient         func(string, string) MQTTClient
+	funcNewPahoMQTTClientWithWill func(string, string, MQTTMessage) MQTTClient
 )
 
 // MQTTMessageHandler is a handler of MQTTMessages
@@ -44,3 +45,21 @@ func NewPahoMQTTClient(server, clientID string) MQTTClient {
 	}
 	return funcNewPahoMQTTClient(server, clientID)
 }
+
+// NewPahoMQTTClientWithWill returns new Paho mqtt client with last will message,
+// use DriverAvailabilityMessage(driverID, AvailabilityOffline) for drivers
+func NewPahoMQTTClientWithWill(server, clientID string, will MQTTMessage) MQTTClient {
+	if funcNewPahoMQTTClientWithWill != nil {
+		return funcNewPahoMQTTClientWithWill(server, clientID, will)
+	}
+	funcSym, errSym := plug.Lookup("NewPahoMQTTClientWithWill")
+	if errSym != nil {
+		log.Fatalf("Error in lookup symbol: %v", errSym)
+	}
+	var okResolve bool
+	funcNewPahoMQTTClientWithWill, okResolve = funcSym.(func(string, string, MQTTMessage) MQTTClient)
+	if !okResolve {
+		log.Fatal("Wrong sign on resolving func")
+	}
+	return funcNewPahoMQTTClientWithWill(server, clientID, will)
+}
@@ -0,0 +1,853 @@
+package wbgong
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	topicDevicesPrefix = "devices"
+	topicControls      = "controls"
+	topicMeta          = "meta"
+	topicOn            = "on"
+)
+
+// externalMeta keeps retained meta of external device or control by source
+// (like MetaTreeDevice): legacy subtopics payloads and meta v2 JSON.
+// Effective meta is legacy meta converted to JSON and overridden by meta v2
+type externalMeta struct {
+	isDevice bool
+	legacy   map[string]string
+	v2       MetaInfo
+}
+
+type externalControlState struct {
+	control  Control
+	rawValue string
+	hasValue bool
+	meta     externalMeta
+}
+
+type externalDeviceState struct {
+	device   ExternalDevice
+	controls map[string]*externalControlState
+	meta     externalMeta
+}
+
+// MQTTDriverBackend is a DriverBackend which works over MQTTClient directly.
+// Local devices are published according to conventions and MetaPublishMode,
+// external devices are subscribed according to DeviceFilter and incoming messages
+// are translated to driver events
+type MQTTDriverBackend struct {
+	mu             sync.Mutex
+	client         MQTTClient
+	metaMode       MetaPublishMode
+	frontend       DriverFrontend
+	deviceFactory  ExternalDeviceFactory
+	controlFactory ControlFactory
+	filters        *FilterSet
+	filterTopics   []string
+	external       map[string]*externalDeviceState
+	local          map[string]map[string]Control
+	started        bool
+}
+
+// NewMQTTDriverBackend creates backend working over given client.
+// Client is started and stopped by backend
+func NewMQTTDriverBackend(client MQTTClient, metaMode MetaPublishMode) *MQTTDriverBackend {
+	return &MQTTDriverBackend{
+		client:   client,
+		metaMode: metaMode,
+		filters:  NewFilterSet(&NoDevicesFilter{}),
+		external: make(map[string]*externalDeviceState),
+		local:    make(map[string]map[string]Control),
+	}
+}
+
+func (b *MQTTDriverBackend) Start() error {
+	b.mu.Lock()
+	if b.started {
+		b.mu.Unlock()
+		return BackendActiveError
+	}
+	b.started = true
+	b.mu.Unlock()
+
+	b.client.Start()
+	b.client.Publish(DriverAvailabilityMessage(b.frontend.GetId(), AvailabilityOnline))
+	b.frontend.PushEvent(StartEvent{})
+	b.resubscribe()
+	return nil
+}
+
+func (b *MQTTDriverBackend) Stop() {
+	b.mu.Lock()
+	if !b.started {
+		b.mu.Unlock()
+		return
+	}
+	b.started = false
+	topics := b.filterTopics
+	b.filterTopics = nil
+	b.mu.Unlock()
+
+	if len(topics) > 0 {
+		b.client.Unsubscribe(topics...)
+	}
+	b.client.PublishSynced(DriverAvailabilityMessage(b.frontend.GetId(), AvailabilityOffline))
+	b.client.Stop()
+	b.frontend.PushEvent(StopEvent{})
+}
+
+func (b *MQTTDriverBackend) SetFrontend(f DriverFrontend) {
+	b.frontend = f
+}
+
+func (b *MQTTDriverBackend) SetExternalDeviceFactory(f ExternalDeviceFactory) {
+	b.deviceFactory = f
+}
+
+func (b *MQTTDriverBackend) SetControlFactory(f ControlFactory) {
+	b.controlFactory = f
+}
+
+// filterSubscriptionTopics converts filter pairs to MQTT subscription topics
+func filterSubscriptionTopics(pairs []DeviceControlPair) []string {
+	topics := make([]string, 0, len(pairs)*5)
+	seen := make(map[string]bool)
+	for _, p := range pairs {
+		dev, ctrl := p.GetDeviceID(), p.GetControlID()
+		for _, topic := range []string{
+			fmt.Sprintf(CONV_DEVICE_META_V2_FMT, dev),
+			fmt.Sprintf(CONV_DEVICE_META_FMT, dev, CONV_SUBTOPIC_ALL),
+			fmt.Sprintf(CONV_CONTROL_VALUE_FMT, dev, ctrl),
+			fmt.Sprintf(CONV_CONTROL_META_V2_FMT, dev, ctrl),
+			fmt.Sprintf(CONV_CONTROL_ALL_META_FMT, dev, ctrl),
+		} {
+			if !seen[topic] {
+				seen[topic] = true
+				topics = append(topics, topic)
+			}
+		}
+	}
+	return topics
+}
+
+// topicsDiff returns topics from a missing in b
+func topicsDiff(a, b []string) []string {
+	inB := make(map[string]bool, len(b))
+	for _, topic := range b {
+		inB[topic] = true
+	}
+	var r []string
+	for _, topic := range a {
+		if !inB[topic] {
+			r = append(r, topic)
+		}
+	}
+	return r
+}
+
+func signaledChan() chan struct{} {
+	c := make(chan struct{}, 1)
+	c <- struct{}{}
+	return c
+}
+
+// SetFilter replaces external devices filter, forgets known external devices
+// (ExternalDeviceRemovedEvent is emitted for each of them) and reloads retained messages.
+// Returned channel is signaled after ReadyEvent
+func (b *MQTTDriverBackend) SetFilter(f DeviceFilter) <-chan struct{} {
+	b.mu.Lock()
+	b.filters = NewFilterSet(f)
+	removed := make([]DriverEvent, 0, len(b.external))
+	for _, id := range sortedKeys(b.external) {
+		removed = append(removed, ExternalDeviceRemovedEvent{Device: b.external[id].device})
+	}
+	b.external = make(map[string]*externalDeviceState)
+	started := b.started
+	b.mu.Unlock()
+
+	for _, e := range removed {
+		b.frontend.PushEvent(e)
+	}
+
+	if !started {
+		return signaledChan()
+	}
+	return b.resubscribe()
+}
+
+// resubscribe drops all filter subscriptions and subscribes again to reload retained messages
+func (b *MQTTDriverBackend) resubscribe() <-chan struct{} {
+	b.mu.Lock()
+	old := b.filterTopics
+	topics := filterSubscriptionTopics(b.filters.Topics())
+	b.filterTopics = topics
+	b.mu.Unlock()
+
+	ready := make(chan struct{}, 1)
+	if len(old) > 0 {
+		b.client.Unsubscribe(old...)
+	}
+	if len(topics) > 0 {
+		b.client.Subscribe(b.handleMessage, topics...)
+	}
+	b.client.WaitForRetained(func() {
+		b.frontend.PushEvent(ReadyEvent{})
+		ready <- struct{}{}
+	})
+	return ready
+}
+
+// topicCovers checks whether subscription topic (with "+" wildcards only) covers topic
+func topicCovers(sub, topic string) bool {
+	subParts, parts := strings.Split(sub, "/"), strings.Split(topic, "/")
+	if len(subParts) != len(parts) {
+		return false
+	}
+	for i, part := range subParts {
+		if part != CONV_SUBTOPIC_ALL && part != parts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// subscribeFilterTopics updates filter subscriptions, topics from resubscribe
+// are subscribed again without unsubscribing, so live messages aren't lost and broker
+// resends retained messages for them (subscription to existing topic filter replaces it).
+// Returned channel is signaled after retained messages are received
+func (b *MQTTDriverBackend) subscribeFilterTopics(unsubscribe, subscribe, resubscribe []string) <-chan struct{} {
+	if len(unsubscribe) > 0 {
+		b.client.Unsubscribe(unsubscribe...)
+	}
+	subscribe = append(subscribe, resubscribe...)
+	if len(subscribe) == 0 {
+		return signaledChan()
+	}
+	ready := make(chan struct{}, 1)
+	b.client.Subscribe(b.handleMessage, subscribe...)
+	b.client.WaitForRetained(func() {
+		ready <- struct{}{}
+	})
+	return ready
+}
+
+// AddToFilter adds filter to the set and subscribes to its topics.
+// Already subscribed topics covering topics of added filter are subscribed again,
+// so retained messages of newly matched devices are received
+// (resent retained messages of known devices produce no events unless something changed).
+// Returned channel is signaled after retained messages are received
+func (b *MQTTDriverBackend) AddToFilter(f DeviceFilter) <-chan struct{} {
+	b.mu.Lock()
+	if !b.filters.Add(f) || !b.started {
+		b.mu.Unlock()
+		return signaledChan()
+	}
+	topics := filterSubscriptionTopics(b.filters.Topics())
+	added := topicsDiff(topics, b.filterTopics)
+	removed := topicsDiff(b.filterTopics, topics)
+	var overlapping []string
+	own := filterSubscriptionTopics(f.Topics())
+	for _, topic := range topicsDiff(topics, added) {
+		for _, t := range own {
+			if topicCovers(topic, t) {
+				overlapping = append(overlapping, topic)
+				break
+			}
+		}
+	}
+	b.filterTopics = topics
+	b.mu.Unlock()
+
+	return b.subscribeFilterTopics(removed, added, overlapping)
+}
+
+// RemoveFromFilter removes filter from the set, updates subscriptions
+// and removes external devices and controls which don't match remaining filters
+// (ExternalDeviceControlRemovedEvent and ExternalDeviceRemovedEvent are emitted).
+// Returned channel is signaled after retained messages for new topics are received
+func (b *MQTTDriverBackend) RemoveFromFilter(f DeviceFilter) <-chan struct{} {
+	b.mu.Lock()
+	if !b.filters.Remove(f) || !b.started {
+		b.mu.Unlock()
+		return signaledChan()
+	}
+	topics := filterSubscriptionTopics(b.filters.Topics())
+	added := topicsDiff(topics, b.filterTopics)
+	removed := topicsDiff(b.filterTopics, topics)
+	b.filterTopics = topics
+	events := b.removeUnmatchedExternal()
+	b.mu.Unlock()
+
+	for _, e := range events {
+		b.frontend.PushEvent(e)
+	}
+	return b.subscribeFilterTopics(removed, added, nil)
+}
+
+// removeUnmatchedExternal removes external controls and devices which don't match filters,
+// must be called under lock
+func (b *MQTTDriverBackend) removeUnmatchedExternal() (events []DriverEvent) {
+	for _, id := range sortedKeys(b.external) {
+		dev := b.external[id]
+		for _, ctrlID := range sortedKeys(dev.controls) {
+			if !b.filters.MatchTopic(fmt.Sprintf(CONV_CONTROL_VALUE_FMT, id, ctrlID)) {
+				events = append(events, ExternalDeviceControlRemovedEvent{Device: dev.device, Control: dev.controls[ctrlID].control})
+				delete(dev.controls, ctrlID)
+			}
+		}
+		if len(dev.controls) == 0 && !b.filters.MatchTopic(fmt.Sprintf(CONV_DEVICE_META_V2_FMT, id)) {
+			delete(b.external, id)
+			events = append(events, ExternalDeviceRemovedEvent{Device: dev.device})
+		}
+	}
+	return events
+}
+
+func errorChan(err error) <-chan error {
+	c := make(chan error, 1)
+	c <- err
+	return c
+}
+
+func (b *MQTTDriverBackend) publishValue(topic, payload string, retained bool) {
+	b.client.Publish(MQTTMessage{
+		Topic:    topic,
+		Payload:  payload,
+		QoS:      1,
+		Retained: retained,
+	})
+}
+
+// publishMeta publishes meta in formats selected by MetaPublishMode
+func (b *MQTTDriverBackend) publishMeta(v2Topic, legacyFmt string, meta MetaInfo, isDevice bool) error {
+	if b.metaMode.PublishV2() {
+		msg, err := metaJsonMessage(v2Topic, meta)
+		if err != nil {
+			return err
+		}
+		b.client.Publish(msg)
+	}
+	if b.metaMode.PublishLegacy() {
+		legacy, err := MetaJsonToLegacy(meta, isDevice)
+		if err != nil {
+			return err
+		}
+		for _, key := range sortedKeys(legacy) {
+			b.publishValue(fmt.Sprintf(legacyFmt, key), legacy[key], true)
+		}
+	}
+	return nil
+}
+
+func (b *MQTTDriverBackend) clearMeta(v2Topic, legacyFmt string, meta MetaInfo, isDevice bool) {
+	if b.metaMode.PublishV2() {
+		b.client.Publish(clearRetainedMessage(v2Topic))
+	}
+	if b.metaMode.PublishLegacy() {
+		legacy, _ := MetaJsonToLegacy(meta, isDevice)
+		for _, key := range sortedKeys(legacy) {
+			b.client.Publish(clearRetainedMessage(fmt.Sprintf(legacyFmt, key)))
+		}
+	}
+}
+
+func (b *MQTTDriverBackend) deviceMeta(dev LocalDevice) MetaInfo {
+	meta := dev.GetMetaJson().Clone()
+	if _, ok := meta[CONV_META_SUBTOPIC_DRIVER]; !ok {
+		meta[CONV_META_SUBTOPIC_DRIVER] = b.frontend.GetId()
+	}
+	return meta
+}
+
+func (b *MQTTDriverBackend) publishDeviceMeta(dev LocalDevice) error {
+	id := dev.GetId()
+	return b.publishMeta(fmt.Sprintf(CONV_DEVICE_META_V2_FMT, id),
+		legacyDeviceMetaFmt(id), b.deviceMeta(dev), true)
+}
+
+func (b *MQTTDriverBackend) publishControlMeta(ctrl Control) error {
+	dev, id := ctrl.GetDevice().GetId(), ctrl.GetId()
+	return b.publishMeta(fmt.Sprintf(CONV_CONTROL_META_V2_FMT, dev, id),
+		legacyControlMetaFmt(dev, id), ctrl.GetMetaJson(), false)
+}
+
+// legacyDeviceMetaFmt returns format string with meta subtopic parameter only
+func legacyDeviceMetaFmt(dev string) string {
+	return fmt.Sprintf(CONV_DEVICE_META_FMT, dev, "%s")
+}
+
+// legacyControlMetaFmt returns format string with meta subtopic parameter only
+func legacyControlMetaFmt(dev, ctrl string) string {
+	return fmt.Sprintf(CONV_CONTROL_META_FMT, dev, ctrl, "%s")
+}
+
+// publishMetaKey publishes single changed meta key: meta v2 JSON is republished
+// as a whole (see publishMeta), but only subtopic of changed key is published in legacy mode.
+// Nil value clears legacy subtopic
+func (b *MQTTDriverBackend) publishMetaKey(v2Topic, legacyFmt string, meta MetaInfo, key string, value any, isDevice bool) error {
+	if b.metaMode.PublishV2() {
+		msg, err := metaJsonMessage(v2Topic, meta)
+		if err != nil {
+			return err
+		}
+		b.client.Publish(msg)
+	}
+	if !b.metaMode.PublishLegacy() {
+		return nil
+	}
+	if value == nil {
+		legacyKey := key
+		if isDevice && key == CONV_META_SUBTOPIC_TITLE_V2 {
+			legacyKey = CONV_META_SUBTOPIC_TITLE
+		}
+		b.client.Publish(clearRetainedMessage(fmt.Sprintf(legacyFmt, legacyKey)))
+		return nil
+	}
+	legacy, err := MetaJsonToLegacy(MetaInfo{key: value}, isDevice)
+	if err != nil {
+		return err
+	}
+	for _, legacyKey := range sortedKeys(legacy) {
+		b.publishValue(fmt.Sprintf(legacyFmt, legacyKey), legacy[legacyKey], true)
+	}
+	return nil
+}
+
+func (b *MQTTDriverBackend) NewDevice(dev LocalDevice) <-chan error {
+	id := dev.GetId()
+	b.mu.Lock()
+	if _, ok := b.local[id]; !ok {
+		b.local[id] = make(map[string]Control)
+	}
+	b.mu.Unlock()
+
+	if err := b.publishDeviceMeta(dev); err != nil {
+		return errorChan(err)
+	}
+	b.client.Subscribe(b.handleMessage, fmt.Sprintf(CONV_CONTROL_ON_VALUE_FMT, id, CONV_SUBTOPIC_ALL))
+	return errorChan(nil)
+}
+
+func (b *MQTTDriverBackend) RemoveDevice(dev LocalDevice) <-chan error {
+	id := dev.GetId()
+	b.mu.Lock()
+	controls := b.local[id]
+	delete(b.local, id)
+	b.mu.Unlock()
+
+	b.client.Unsubscribe(fmt.Sprintf(CONV_CONTROL_ON_VALUE_FMT, id, CONV_SUBTOPIC_ALL))
+	for _, ctrlID := range sortedKeys(controls) {
+		b.clearControl(controls[ctrlID])
+	}
+	b.clearMeta(fmt.Sprintf(CONV_DEVICE_META_V2_FMT, id),
+		legacyDeviceMetaFmt(id), b.deviceMeta(dev), true)
+	return errorChan(nil)
+}
+
+func (b *MQTTDriverBackend) NewDeviceControl(control Control) <-chan error {
+	dev, id := control.GetDevice().GetId(), control.GetId()
+	b.mu.Lock()
+	if controls, ok := b.local[dev]; ok {
+		controls[id] = control
+	}
+	b.mu.Unlock()
+
+	if err := b.publishControlMeta(control); err != nil {
+		return errorChan(err)
+	}
+	if !control.GetLazyInit() {
+		b.publishValue(fmt.Sprintf(CONV_CONTROL_VALUE_FMT, dev, id), control.GetRawValue(), control.IsRetained())
+	}
+	return errorChan(nil)
+}
+
+func (b *MQTTDriverBackend) clearControl(control Control) {
+	dev, id := control.GetDevice().GetId(), control.GetId()
+	b.client.Publish(clearRetainedMessage(fmt.Sprintf(CONV_CONTROL_VALUE_FMT, dev, id)))
+	b.clearMeta(fmt.Sprintf(CONV_CONTROL_META_V2_FMT, dev, id), legacyControlMetaFmt(dev, id),
+		control.GetMetaJson(), false)
+}
+
+func (b *MQTTDriverBackend) RemoveControl(control Control) <-chan error {
+	b.mu.Lock()
+	if controls, ok := b.local[control.GetDevice().GetId()]; ok {
+		delete(controls, control.GetId())
+	}
+	b.mu.Unlock()
+
+	b.clearControl(control)
+	return errorChan(nil)
+}
+
+func (b *MQTTDriverBackend) UpdateControlValue(control Control, rawValue string) <-chan error {
+	b.publishValue(fmt.Sprintf(CONV_CONTROL_VALUE_FMT, control.GetDevice().GetId(), control.GetId()),
+		rawValue, control.IsRetained())
+	return errorChan(nil)
+}
+
+func (b *MQTTDriverBackend) SetOnValue(control Control, rawValue string) <-chan error {
+	b.publishValue(fmt.Sprintf(CONV_CONTROL_ON_VALUE_FMT, control.GetDevice().GetId(), control.GetId()),
+		rawValue, false)
+	return errorChan(nil)
+}
+
+func (b *MQTTDriverBackend) UpdateControlMeta(control Control, meta string, value any) <-chan error {
+	dev, id := control.GetDevice().GetId(), control.GetId()
+	return errorChan(b.publishMetaKey(fmt.Sprintf(CONV_CONTROL_META_V2_FMT, dev, id),
+		legacyControlMetaFmt(dev, id), control.GetMetaJson(), meta, value, false))
+}
+
+func (b *MQTTDriverBackend) UpdateControlMetaJson(control Control) <-chan error {
+	return errorChan(b.publishControlMeta(control))
+}
+
+func (b *MQTTDriverBackend) UpdateDeviceMeta(dev LocalDevice, meta string, value any) <-chan error {
+	id := dev.GetId()
+	return errorChan(b.publishMetaKey(fmt.Sprintf(CONV_DEVICE_META_V2_FMT, id),
+		legacyDeviceMetaFmt(id), b.deviceMeta(dev), meta, value, true))
+}
+
+func (b *MQTTDriverBackend) UpdateDeviceMetaJson(dev LocalDevice) <-chan error {
+	return errorChan(b.publishDeviceMeta(dev))
+}
+
+func (b *MQTTDriverBackend) ApplyControlBatch(batch *ControlBatch) <-chan error {
+	for _, v := range batch.Values {
+		b.UpdateControlValue(v.Control, v.RawValue)
+	}
+	published := make(map[Control]bool)
+	for _, m := range batch.Metas {
+		if published[m.Control] {
+			continue
+		}
+		published[m.Control] = true
+		if err := b.publishControlMeta(m.Control); err != nil {
+			return errorChan(err)
+		}
+	}
+	return errorChan(nil)
+}
+
+func (b *MQTTDriverBackend) RemoveExternalDevice(dev ExternalDevice) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.external, dev.GetId())
+}
+
+func (b *MQTTDriverBackend) RemoveExternalControl(ctrl Control) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if dev, ok := b.external[ctrl.GetDevice().GetId()]; ok {
+		delete(dev.controls, ctrl.GetId())
+	}
+}
+
+// devicesTopic is a parsed /devices/... topic
+type devicesTopic struct {
+	device  string
+	control string
+	on      bool
+	meta    bool
+	metaKey string
+}
+
+func parseDevicesTopic(topic string) (t devicesTopic, ok bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 4 || parts[0] != "" || parts[1] != topicDevicesPrefix {
+		return t, false
+	}
+	t.device = parts[2]
+	rest := parts[3:]
+	if rest[0] == topicControls {
+		if len(rest) < 2 {
+			return t, false
+		}
+		t.control = rest[1]
+		rest = rest[2:]
+		if len(rest) == 1 && rest[0] == topicOn {
+			t.on = true
+			return t, true
+		}
+	}
+	switch {
+	case len(rest) == 0:
+		return t, t.control != ""
+	case rest[0] != topicMeta || len(rest) > 2:
+		return t, false
+	}
+	t.meta = true
+	if len(rest) == 2 {
+		t.metaKey = rest[1]
+	}
+	return t, true
+}
+
+func (b *MQTTDriverBackend) handleMessage(msg MQTTMessage) {
+	t, ok := parseDevicesTopic(msg.Topic)
+	if !ok {
+		return
+	}
+	b.mu.Lock()
+	if t.on {
+		ctrl, ok := b.local[t.device][t.control]
+		b.mu.Unlock()
+		if ok {
+			b.frontend.PushEvent(ControlOnValueEvent{Control: ctrl, RawValue: msg.Payload})
+		}
+		return
+	}
+	_, isLocal := b.local[t.device]
+	matches := !isLocal && b.filters.MatchTopic(msg.Topic)
+	b.mu.Unlock()
+
+	if !matches {
+		return
+	}
+	for _, e := range b.externalMessage(t, msg.Payload, msg.Retained) {
+		b.frontend.PushEvent(e)
+	}
+}
+
+// externalMessage translates message of external device to events.
+// Empty payloads clear retained topics: control is removed when its value and meta
+// are cleared, device is removed when its meta is cleared and it has no controls.
+// Retained values equal to known ones are resent by broker on resubscription, they're skipped
+func (b *MQTTDriverBackend) externalMessage(t devicesTopic, payload string, retained bool) []DriverEvent {
+	dev, events := b.externalDevice(t.device, payload)
+	if dev == nil {
+		return events
+	}
+
+	if t.control == "" {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		events = append(events, b.deviceMetaEvents(dev, t, payload)...)
+		return append(events, b.cleanupExternal(dev, nil)...)
+	}
+
+	ctrl, ctrlEvents := b.externalControl(dev, t.control, payload)
+	events = append(events, ctrlEvents...)
+	if ctrl == nil {
+		return events
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !t.meta {
+		prev := ctrl.rawValue
+		if retained && payload == prev {
+			return events
+		}
+		ctrl.rawValue, ctrl.hasValue = payload, payload != ""
+		events = append(events, ControlValueEvent{Control: ctrl.control, RawValue: payload, PrevRawValue: prev})
+	} else {
+		events = append(events, b.controlMetaEvents(ctrl, t, payload)...)
+	}
+	return append(events, b.cleanupExternal(dev, ctrl)...)
+}
+
+// externalDevice returns known external device or creates new one for non-empty message.
+// Factory is called without lock held
+func (b *MQTTDriverBackend) externalDevice(id, payload string) (*externalDeviceState, []DriverEvent) {
+	b.mu.Lock()
+	dev, ok := b.external[id]
+	b.mu.Unlock()
+	if ok || payload == "" {
+		return dev, nil
+	}
+	if b.deviceFactory == nil {
+		Error.Printf("no external device factory, device %s is skipped", id)
+		return nil, nil
+	}
+	var driver DeviceDriver
+	if d, ok := b.frontend.(DeviceDriver); ok {
+		driver = d
+	}
+	device, err := b.deviceFactory(id, driver)
+	if err != nil {
+		Error.Printf("failed to create external device %s: %v", id, err)
+		return nil, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if dev, ok := b.external[id]; ok {
+		return dev, nil
+	}
+	dev = &externalDeviceState{
+		device:   device,
+		controls: make(map[string]*externalControlState),
+		meta:     newExternalMeta(true),
+	}
+	b.external[id] = dev
+	return dev, []DriverEvent{NewExternalDeviceEvent{Device: device}}
+}
+
+// externalControl returns known external control or creates new one for non-empty message.
+// Factory is called without lock held
+func (b *MQTTDriverBackend) externalControl(dev *externalDeviceState, id, payload string) (*externalControlState, []DriverEvent) {
+	b.mu.Lock()
+	ctrl, ok := dev.controls[id]
+	b.mu.Unlock()
+	if ok || payload == "" {
+		return ctrl, nil
+	}
+	if b.controlFactory == nil {
+		Error.Printf("no control factory, control %s/%s is skipped", dev.device.GetId(), id)
+		return nil, nil
+	}
+	control, err := b.controlFactory(NewControlArgs().SetDevice(dev.device).SetId(id))
+	if err != nil {
+		Error.Printf("failed to create external control %s/%s: %v", dev.device.GetId(), id, err)
+		return nil, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ctrl, ok := dev.controls[id]; ok {
+		return ctrl, nil
+	}
+	ctrl = &externalControlState{control: control, meta: newExternalMeta(false)}
+	dev.controls[id] = ctrl
+	return ctrl, []DriverEvent{NewExternalDeviceControlEvent{Device: dev.device, Control: control}}
+}
+
+// cleanupExternal removes control and device which have no retained topics left,
+// must be called under lock
+func (b *MQTTDriverBackend) cleanupExternal(dev *externalDeviceState, ctrl *externalControlState) (events []DriverEvent) {
+	if ctrl != nil && !ctrl.hasValue && ctrl.meta.empty() {
+		id := ctrl.control.GetId()
+		if dev.controls[id] == ctrl {
+			delete(dev.controls, id)
+			events = append(events, ExternalDeviceControlRemovedEvent{Device: dev.device, Control: ctrl.control})
+		}
+	}
+	id := dev.device.GetId()
+	if len(dev.controls) == 0 && dev.meta.empty() && b.external[id] == dev {
+		delete(b.external, id)
+		events = append(events, ExternalDeviceRemovedEvent{Device: dev.device})
+	}
+	return events
+}
+
+func newExternalMeta(isDevice bool) externalMeta {
+	return externalMeta{isDevice: isDevice, legacy: make(map[string]string)}
+}
+
+// empty checks whether there are no retained meta topics
+func (m *externalMeta) empty() bool {
+	return len(m.legacy) == 0 && m.v2 == nil
+}
+
+// set stores payload of meta topic, key is empty for meta v2 JSON.
+// Empty payload clears topic. Malformed payloads are rejected, so stored meta
+// is always convertible
+func (m *externalMeta) set(key, payload string) error {
+	if key == "" {
+		v2, err := parseMetaJson(payload)
+		if err != nil {
+			return err
+		}
+		m.v2 = v2
+		return nil
+	}
+	if payload == "" {
+		delete(m.legacy, key)
+		return nil
+	}
+	if key != CONV_META_SUBTOPIC_ERROR {
+		if _, err := m.legacyToJson(map[string]string{key: payload}); err != nil {
+			return err
+		}
+	}
+	m.legacy[key] = payload
+	return nil
+}
+
+func (m *externalMeta) legacyToJson(legacy map[string]string) (MetaInfo, error) {
+	if m.isDevice {
+		return LegacyDeviceMetaToJson(legacy)
+	}
+	return LegacyControlMetaToJson(legacy)
+}
+
+// effective returns meta in JSON form. Live 'error' subtopic isn't a part of
+// meta v2 JSON, but it's kept as is
+func (m *externalMeta) effective() MetaInfo {
+	meta, err := m.legacyToJson(m.legacy)
+	if err != nil {
+		meta = make(MetaInfo)
+	}
+	if e := m.legacy[CONV_META_SUBTOPIC_ERROR]; e != "" {
+		meta[CONV_META_SUBTOPIC_ERROR] = e
+	}
+	for key, value := range m.v2 {
+		meta[key] = value
+	}
+	return meta
+}
+
+// deviceMetaEvents updates device meta and returns NewExternalDeviceMetaEvent for every
+// changed key. Values are in legacy form (see MetaJsonToLegacy), removed keys get empty value.
+// Must be called under lock
+func (b *MQTTDriverBackend) deviceMetaEvents(dev *externalDeviceState, t devicesTopic, payload string) []DriverEvent {
+	prev, _ := MetaJsonToLegacy(dev.meta.effective(), true)
+	if err := dev.meta.set(t.metaKey, payload); err != nil {
+		Error.Printf("malformed meta of device %s: %v", t.device, err)
+		return nil
+	}
+	cur, err := MetaJsonToLegacy(dev.meta.effective(), true)
+	if err != nil {
+		Error.Printf("malformed meta of device %s: %v", t.device, err)
+		return nil
+	}
+	var events []DriverEvent
+	for _, key := range sortedKeys(mergedKeys(prev, cur)) {
+		if value := cur[key]; value != prev[key] {
+			events = append(events, NewExternalDeviceMetaEvent{Device: dev.device, Type: key, Value: value})
+		}
+	}
+	return events
+}
+
+// controlMetaEvents updates control meta and returns NewExternalDeviceControlMetaEvent for every
+// changed key. Values are in meta v2 JSON form, removed keys get nil value.
+// Must be called under lock
+func (b *MQTTDriverBackend) controlMetaEvents(ctrl *externalControlState, t devicesTopic, payload string) []DriverEvent {
+	prev := ctrl.meta.effective()
+	if err := ctrl.meta.set(t.metaKey, payload); err != nil {
+		Error.Printf("malformed meta of control %s/%s: %v", t.device, t.control, err)
+		return nil
+	}
+	cur := ctrl.meta.effective()
+	var events []DriverEvent
+	for _, key := range sortedKeys(mergedKeys(prev, cur)) {
+		value, ok := cur[key]
+		if prevValue, hasPrev := prev[key]; ok == hasPrev && metaValuesEqual(prevValue, value) {
+			continue
+		}
+		events = append(events, NewExternalDeviceControlMetaEvent{
+			Control:   ctrl.control,
+			Type:      key,
+			Value:     value,
+			PrevValue: prev[key],
+		})
+	}
+	return events
+}
+
+// mergedKeys returns set of keys of both maps
+func mergedKeys[V any](a, b map[string]V) map[string]bool {
+	keys := make(map[string]bool, len(a)+len(b))
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+	return keys
+}
@@ -0,0 +1,346 @@
+package wbgong_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wirenboard/wbgong"
+	"github.com/wirenboard/wbgong/testutils"
+)
+
+// testExternalDevice implements only methods used by backend
+type testExternalDevice struct {
+	wbgong.ExternalDevice
+	id string
+}
+
+func (d *testExternalDevice) GetId() string {
+	return d.id
+}
+
+type testDevice struct {
+	wbgong.LocalDevice
+	id   string
+	meta wbgong.MetaInfo
+}
+
+func (d *testDevice) GetId() string                { return d.id }
+func (d *testDevice) GetMetaJson() wbgong.MetaInfo { return d.meta }
+
+// testControl is used both as local and external control
+type testControl struct {
+	wbgong.Control
+	dev   wbgong.Device
+	id    string
+	value string
+	meta  wbgong.MetaInfo
+}
+
+func (c *testControl) GetId() string                { return c.id }
+func (c *testControl) GetDevice() wbgong.Device     { return c.dev }
+func (c *testControl) GetMetaJson() wbgong.MetaInfo { return c.meta }
+func (c *testControl) GetRawValue() string          { return c.value }
+func (c *testControl) GetLazyInit() bool            { return false }
+func (c *testControl) IsRetained() bool             { return true }
+
+type testControlArgs struct {
+	wbgong.ControlArgs
+	dev wbgong.Device
+	id  string
+}
+
+func (a *testControlArgs) SetDevice(dev wbgong.Device) wbgong.ControlArgs { a.dev = dev; return a }
+func (a *testControlArgs) SetId(id string) wbgong.ControlArgs             { a.id = id; return a }
+
+func controlName(c wbgong.Control) string {
+	return c.GetDevice().GetId() + "/" + c.GetId()
+}
+
+// testFrontend records events of external devices
+type testFrontend struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (f *testFrontend) GetId() string {
+	return "test"
+}
+
+func (f *testFrontend) SetBackend(b wbgong.DriverBackend) {}
+
+func (f *testFrontend) PushEvent(e wbgong.DriverEvent) {
+	var s string
+	switch e := e.(type) {
+	case wbgong.NewExternalDeviceEvent:
+		s = "new " + e.Device.GetId()
+	case wbgong.NewExternalDeviceMetaEvent:
+		s = fmt.Sprintf("meta %s %s=%s", e.Device.GetId(), e.Type, e.Value)
+	case wbgong.ExternalDeviceRemovedEvent:
+		s = "removed " + e.Device.GetId()
+	case wbgong.NewExternalDeviceControlEvent:
+		s = "new control " + controlName(e.Control)
+	case wbgong.NewExternalDeviceControlMetaEvent:
+		s = fmt.Sprintf("control meta %s %s=%v", controlName(e.Control), e.Type, e.Value)
+	case wbgong.ExternalDeviceControlRemovedEvent:
+		s = "removed control " + controlName(e.Control)
+	case wbgong.ControlValueEvent:
+		s = fmt.Sprintf("value %s=%s", controlName(e.Control), e.RawValue)
+	case wbgong.ControlOnValueEvent:
+		s = fmt.Sprintf("on %s=%s", controlName(e.Control), e.RawValue)
+	default:
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, s)
+}
+
+func (f *testFrontend) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	events := f.events
+	f.events = nil
+	return events
+}
+
+func mustGlob(t *testing.T, dev string) wbgong.DeviceFilter {
+	f, err := wbgong.NewGlobFilter(dev, "")
+	require.NoError(t, err)
+	return f
+}
+
+func TestMQTTBackendFilterDelta(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial wbgong.DeviceFilter
+	}{
+		{"overlapping wildcard", mustGlob(t, "wb-*")},
+		{"literal", wbgong.NewDeviceListFilter("wb-1")},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newBackendFixture(t, wbgong.CONV_META_MODE_V2)
+			for _, dev := range []string{"wb-1", "foo-1"} {
+				f.publish(fmt.Sprintf(wbgong.CONV_DEVICE_META_FMT, dev, "name"), dev)
+			}
+			f.publish("/devices/wb-1/controls/K1", "1")
+
+			<-f.backend.SetFilter(tc.initial)
+			assert.ElementsMatch(t, []string{
+				"new wb-1", "meta wb-1 name=wb-1", "new control wb-1/K1", "value wb-1/K1=1",
+			}, f.frontend.take())
+
+			// retained messages of known devices resent for overlapping subscriptions produce no events
+			added := mustGlob(t, "foo-*")
+			<-f.backend.AddToFilter(added)
+			assert.ElementsMatch(t, []string{"new foo-1", "meta foo-1 name=foo-1"}, f.frontend.take())
+
+			// live values are received once after resubscription
+			assert.Equal(t, []string{"value wb-1/K1=2"}, f.publish("/devices/wb-1/controls/K1", "2"))
+			assert.Equal(t, []string{"value wb-1/K1=2"},
+				f.send(wbgong.MQTTMessage{Topic: "/devices/wb-1/controls/K1", Payload: "2", QoS: 1}),
+				"repeated live value is not a replay")
+
+			// narrowed subscriptions may get retained messages of kept devices again
+			<-f.backend.RemoveFromFilter(added)
+			assert.Equal(t, []string{"removed foo-1"}, f.frontend.take())
+
+			// removed device is not created again by retained messages
+			<-f.backend.AddToFilter(wbgong.NewDeviceListFilter("bar"))
+			assert.Empty(t, f.frontend.take())
+		})
+	}
+}
+
+func retainedMsg(topic, payload string) wbgong.MQTTMessage {
+	return wbgong.MQTTMessage{Topic: topic, Payload: payload, QoS: 1, Retained: true}
+}
+
+// flush waits until messages queued by broker so far are delivered
+func flush(client *testutils.FakeMQTTClient) {
+	done := make(chan struct{})
+	client.WaitForRetained(func() {
+		close(done)
+	})
+	<-done
+}
+
+// retained returns non-empty retained messages of broker
+func retained(broker *testutils.FakeMQTTBroker) map[string]string {
+	var mu sync.Mutex
+	res := make(map[string]string)
+	client := broker.MakeClient("observer")
+	client.Start()
+	client.Subscribe(func(msg wbgong.MQTTMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		if msg.Payload != "" {
+			res[msg.Topic] = msg.Payload
+		}
+	}, "#")
+	flush(client)
+	client.Unsubscribe("#")
+	client.Stop()
+	return res
+}
+
+type backendFixture struct {
+	broker    *testutils.FakeMQTTBroker
+	client    *testutils.FakeMQTTClient
+	publisher *testutils.FakeMQTTClient
+	frontend  *testFrontend
+	backend   *wbgong.MQTTDriverBackend
+}
+
+func newBackendFixture(t *testing.T, mode wbgong.MetaPublishMode) *backendFixture {
+	wbgong.SetTestControlArgs(t, func() wbgong.ControlArgs {
+		return &testControlArgs{}
+	})
+	f := &backendFixture{
+		broker:   testutils.NewFakeMQTTBroker(t, nil),
+		frontend: &testFrontend{},
+	}
+	f.publisher = f.broker.MakeClient("publisher")
+	f.publisher.Start()
+	f.client = f.broker.MakeClient("backend")
+	f.backend = wbgong.NewMQTTDriverBackend(f.client, mode)
+	f.backend.SetFrontend(f.frontend)
+	f.backend.SetExternalDeviceFactory(func(id string, driver wbgong.DeviceDriver) (wbgong.ExternalDevice, error) {
+		return &testExternalDevice{id: id}, nil
+	})
+	f.backend.SetControlFactory(func(args wbgong.ControlArgs) (wbgong.Control, error) {
+		a := args.(*testControlArgs)
+		return &testControl{dev: a.dev, id: a.id}, nil
+	})
+	require.NoError(t, f.backend.Start())
+	return f
+}
+
+// send publishes message and returns events of backend
+func (f *backendFixture) send(msg wbgong.MQTTMessage) []string {
+	f.publisher.Publish(msg)
+	flush(f.client)
+	return f.frontend.take()
+}
+
+func (f *backendFixture) publish(topic, payload string) []string {
+	return f.send(retainedMsg(topic, payload))
+}
+
+func TestMQTTBackendLocalDevice(t *testing.T) {
+	devMeta := map[wbgong.MetaPublishMode]map[string]string{
+		wbgong.CONV_META_MODE_V2: {
+			"/devices/dev/meta":             `{"driver":"test","title":{"en":"Device"}}`,
+			"/devices/dev/controls/K1/meta": `{"order":1,"readonly":true,"type":"switch"}`,
+		},
+		wbgong.CONV_META_MODE_LEGACY: {
+			"/devices/dev/meta/name":                 "Device",
+			"/devices/dev/meta/driver":               "test",
+			"/devices/dev/controls/K1/meta/type":     "switch",
+			"/devices/dev/controls/K1/meta/readonly": "1",
+			"/devices/dev/controls/K1/meta/order":    "1",
+		},
+	}
+	devMeta[wbgong.CONV_META_MODE_BOTH] = make(map[string]string)
+	for _, mode := range []wbgong.MetaPublishMode{wbgong.CONV_META_MODE_V2, wbgong.CONV_META_MODE_LEGACY} {
+		for topic, payload := range devMeta[mode] {
+			devMeta[wbgong.CONV_META_MODE_BOTH][topic] = payload
+		}
+	}
+
+	for mode, meta := range devMeta {
+		t.Run(mode.String(), func(t *testing.T) {
+			f := newBackendFixture(t, mode)
+			dev := &testDevice{id: "dev", meta: wbgong.MetaInfo{"title": wbgong.Title{"en": "Device"}}}
+			ctrl := &testControl{dev: dev, id: "K1", value: "1",
+				meta: wbgong.MetaInfo{"type": "switch", "readonly": true, "order": 1}}
+			require.NoError(t, <-f.backend.NewDevice(dev))
+			require.NoError(t, <-f.backend.NewDeviceControl(ctrl))
+
+			expected := map[string]string{
+				"/devices/test/meta/availability": "online",
+				"/devices/dev/controls/K1":        "1",
+			}
+			for topic, payload := range meta {
+				expected[topic] = payload
+			}
+			assert.Equal(t, expected, retained(f.broker))
+
+			// on values are delivered to frontend, local devices aren't treated as external
+			assert.Equal(t, []string{"on dev/K1=0"}, f.send(wbgong.MQTTMessage{Topic: "/devices/dev/controls/K1/on", Payload: "0", QoS: 1}))
+			assert.Empty(t, f.publish("/devices/dev/controls/K1", "0"))
+
+			require.NoError(t, <-f.backend.RemoveDevice(dev))
+			assert.Equal(t, map[string]string{
+				"/devices/test/meta/availability": "online",
+			}, retained(f.broker))
+
+			f.backend.Stop()
+			assert.Equal(t, map[string]string{
+				"/devices/test/meta/availability": "offline",
+			}, retained(f.broker))
+		})
+	}
+}
+
+func TestMQTTBackendExternalMeta(t *testing.T) {
+	f := newBackendFixture(t, wbgong.CONV_META_MODE_V2)
+	<-f.backend.SetFilter(mustGlob(t, "ext"))
+	f.frontend.take()
+
+	assert.Equal(t, []string{
+		"new ext",
+		"new control ext/K1",
+		"control meta ext/K1 type=value",
+	}, f.publish("/devices/ext/controls/K1/meta/type", "value"))
+	assert.Equal(t, []string{"control meta ext/K1 order=2"}, f.publish("/devices/ext/controls/K1/meta/order", "2"))
+	assert.Empty(t, f.publish("/devices/ext/controls/K1/meta/order", "x"), "malformed meta is skipped")
+	assert.Equal(t, []string{"value ext/K1=5"}, f.publish("/devices/ext/controls/K1", "5"))
+
+	// meta v2 overrides legacy meta, only changed keys are reported
+	assert.Equal(t, []string{"control meta ext/K1 units=W"},
+		f.publish("/devices/ext/controls/K1/meta", `{"type": "value", "order": 2, "units": "W"}`))
+	assert.Equal(t, []string{"control meta ext/K1 order=3"},
+		f.publish("/devices/ext/controls/K1/meta", `{"type": "value", "order": 3, "units": "W"}`))
+	assert.Equal(t, []string{"control meta ext/K1 readonly=true"}, f.publish("/devices/ext/controls/K1/meta/readonly", "1"))
+
+	// clearing meta v2 keeps legacy meta
+	assert.Equal(t, []string{
+		"control meta ext/K1 order=2",
+		"control meta ext/K1 units=<nil>",
+	}, f.publish("/devices/ext/controls/K1/meta", ""))
+
+	// device meta
+	assert.Equal(t, []string{"meta ext name=Ext"}, f.publish("/devices/ext/meta/name", "Ext"))
+	assert.Empty(t, f.publish("/devices/ext/meta", `{"title": {"en": "Ext", "ru": "Экст"}}`))
+	assert.Equal(t, []string{"meta ext name=Ext 2"}, f.publish("/devices/ext/meta", `{"title": {"en": "Ext 2"}}`))
+	assert.Equal(t, []string{"meta ext name=Ext"}, f.publish("/devices/ext/meta", ""))
+}
+
+func TestMQTTBackendExternalRemoval(t *testing.T) {
+	f := newBackendFixture(t, wbgong.CONV_META_MODE_V2)
+	<-f.backend.SetFilter(mustGlob(t, "ext"))
+	f.publish("/devices/ext/meta/name", "Ext")
+	f.publish("/devices/ext/controls/K1/meta/type", "value")
+	f.publish("/devices/ext/controls/K1/meta", `{"type": "value"}`)
+	f.publish("/devices/ext/controls/K1", "1")
+
+	// control is kept while any of its topics is retained
+	assert.Equal(t, []string{"value ext/K1="}, f.publish("/devices/ext/controls/K1", ""))
+	assert.Empty(t, f.publish("/devices/ext/controls/K1/meta", ""))
+	assert.Equal(t, []string{
+		"control meta ext/K1 type=<nil>",
+		"removed control ext/K1",
+	}, f.publish("/devices/ext/controls/K1/meta/type", ""))
+
+	assert.Equal(t, []string{
+		"meta ext name=",
+		"removed ext",
+	}, f.publish("/devices/ext/meta/name", ""))
+
+	// cleared topics don't create devices
+	assert.Empty(t, f.publish("/devices/ext/controls/K2", ""))
+}
@@ -0,0 +1,165 @@
+package wbgong
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PublishPolicy limits publishing of control values.
+// Zero policy publishes every value
+type PublishPolicy struct {
+	// Publish numeric value only if it differs from last published one at least by AbsDeadband
+	AbsDeadband float64
+
+	// Publish numeric value only if it differs from last published one
+	// at least by RelDeadband * |last published value|
+	RelDeadband float64
+
+	// Minimal interval between publishes, latest suppressed value is published
+	// when interval passes (see PublishGate.Flush)
+	MinInterval time.Duration
+
+	// Republish last value if nothing was published for this interval
+	Heartbeat time.Duration
+}
+
+// IsZero checks whether policy doesn't limit anything
+func (p PublishPolicy) IsZero() bool {
+	return p == PublishPolicy{}
+}
+
+// RoundToPrecision rounds value to given precision (e.g. 0.01), zero precision means no rounding
+func RoundToPrecision(v, precision float64) float64 {
+	if precision <= 0 {
+		return v
+	}
+	return math.Round(v/precision) * precision
+}
+
+// PublishGate applies PublishPolicy to stream of control values.
+// Values are rounded to control precision before deadband check,
+// so noise below precision never causes publish.
+// PublishGate is safe for concurrent use
+type PublishGate struct {
+	mu         sync.Mutex
+	policy     PublishPolicy
+	precision  float64
+	hasLast    bool
+	lastRaw    string
+	lastTime   time.Time
+	pendingRaw string
+	hasPending bool
+	forceNext  bool
+}
+
+// NewPublishGate creates gate for control with given precision
+func NewPublishGate(policy PublishPolicy, precision float64) *PublishGate {
+	return &PublishGate{
+		policy:    policy,
+		precision: precision,
+	}
+}
+
+// SetPolicy replaces policy, next value is published unconditionally
+func (g *PublishGate) SetPolicy(policy PublishPolicy, precision float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.policy = policy
+	g.precision = precision
+	g.forceNext = true
+}
+
+// ShouldPublish checks whether value must be published now.
+// If so, value is remembered as last published one
+func (g *PublishGate) ShouldPublish(rawValue string, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.hasLast || g.forceNext || g.policy.IsZero() {
+		g.published(rawValue, now)
+		return true
+	}
+	if !g.changedEnough(rawValue) {
+		// value is within deadband, nothing to publish later
+		g.hasPending = false
+		return false
+	}
+	if g.policy.MinInterval > 0 && now.Sub(g.lastTime) < g.policy.MinInterval {
+		g.pendingRaw = rawValue
+		g.hasPending = true
+		return false
+	}
+	g.published(rawValue, now)
+	return true
+}
+
+func (g *PublishGate) published(rawValue string, now time.Time) {
+	g.hasLast = true
+	g.forceNext = false
+	g.hasPending = false
+	g.lastRaw = rawValue
+	g.lastTime = now
+}
+
+func (g *PublishGate) changedEnough(rawValue string) bool {
+	if rawValue == g.lastRaw {
+		return false
+	}
+	value, errValue := strconv.ParseFloat(rawValue, 64)
+	last, errLast := strconv.ParseFloat(g.lastRaw, 64)
+	if errValue != nil || errLast != nil {
+		// deadband is for numeric values only
+		return true
+	}
+	value = RoundToPrecision(value, g.precision)
+	last = RoundToPrecision(last, g.precision)
+	diff := math.Abs(value - last)
+	if diff == 0 {
+		return false
+	}
+	if g.policy.AbsDeadband > 0 && diff < g.policy.AbsDeadband {
+		return false
+	}
+	if g.policy.RelDeadband > 0 && diff < g.policy.RelDeadband*math.Abs(last) {
+		return false
+	}
+	return true
+}
+
+// Flush returns value which must be published now: latest value suppressed
+// by MinInterval after interval passes, or last published value on heartbeat.
+// Driver calls Flush periodically (see NextDeadline)
+func (g *PublishGate) Flush(now time.Time) (rawValue string, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.hasPending && now.Sub(g.lastTime) >= g.policy.MinInterval {
+		rawValue = g.pendingRaw
+		g.published(rawValue, now)
+		return rawValue, true
+	}
+	if g.hasLast && g.policy.Heartbeat > 0 && now.Sub(g.lastTime) >= g.policy.Heartbeat {
+		rawValue = g.lastRaw
+		g.published(rawValue, now)
+		return rawValue, true
+	}
+	return "", false
+}
+
+// NextDeadline returns time when Flush should be called next,
+// false is returned if there is nothing to flush
+func (g *PublishGate) NextDeadline() (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch {
+	case g.hasPending:
+		return g.lastTime.Add(g.policy.MinInterval), true
+	case g.hasLast && g.policy.Heartbeat > 0:
+		return g.lastTime.Add(g.policy.Heartbeat), true
+	default:
+		return time.Time{}, false
+	}
+}
@@ -0,0 +1,123 @@
+package wbgong
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishGateDeadband(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    PublishPolicy
+		precision float64
+		values    []string
+		published []bool
+	}{
+		{
+			name:      "zero policy",
+			values:    []string{"1", "1", "2"},
+			published: []bool{true, true, true},
+		},
+		{
+			name:      "abs deadband",
+			policy:    PublishPolicy{AbsDeadband: 0.5},
+			values:    []string{"10", "10.4", "10.5", "10.1", "9.9"},
+			published: []bool{true, false, true, false, true},
+		},
+		{
+			name:      "rel deadband",
+			policy:    PublishPolicy{RelDeadband: 0.1},
+			values:    []string{"100", "109", "110", "121", "110"},
+			published: []bool{true, false, true, true, false},
+		},
+		{
+			name:      "precision rounding",
+			policy:    PublishPolicy{AbsDeadband: 0.001},
+			precision: 0.1,
+			values:    []string{"20.01", "20.04", "20.06", "20.14"},
+			published: []bool{true, false, true, false},
+		},
+		{
+			name:      "not numeric",
+			policy:    PublishPolicy{AbsDeadband: 10},
+			values:    []string{"on", "on", "off", "1"},
+			published: []bool{true, false, true, true},
+		},
+	}
+	now := time.Now()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewPublishGate(tc.policy, tc.precision)
+			published := make([]bool, len(tc.values))
+			for i, v := range tc.values {
+				published[i] = g.ShouldPublish(v, now)
+			}
+			assert.Equal(t, tc.published, published)
+		})
+	}
+}
+
+func TestPublishGateMinInterval(t *testing.T) {
+	start := time.Now()
+	g := NewPublishGate(PublishPolicy{MinInterval: time.Second}, 0)
+
+	assert.True(t, g.ShouldPublish("1", start))
+	_, ok := g.NextDeadline()
+	assert.False(t, ok)
+
+	assert.False(t, g.ShouldPublish("2", start.Add(100*time.Millisecond)))
+	assert.False(t, g.ShouldPublish("3", start.Add(200*time.Millisecond)))
+	deadline, ok := g.NextDeadline()
+	assert.True(t, ok)
+	assert.Equal(t, start.Add(time.Second), deadline)
+
+	_, ok = g.Flush(start.Add(500 * time.Millisecond))
+	assert.False(t, ok, "interval hasn't passed yet")
+	v, ok := g.Flush(deadline)
+	assert.True(t, ok)
+	assert.Equal(t, "3", v, "latest suppressed value is flushed")
+	_, ok = g.Flush(deadline.Add(time.Second))
+	assert.False(t, ok)
+
+	// return to published value cancels pending one
+	assert.False(t, g.ShouldPublish("4", deadline.Add(100*time.Millisecond)))
+	assert.False(t, g.ShouldPublish("3", deadline.Add(200*time.Millisecond)))
+	_, ok = g.Flush(deadline.Add(time.Second))
+	assert.False(t, ok)
+
+	assert.True(t, g.ShouldPublish("5", deadline.Add(time.Second)))
+}
+
+func TestPublishGateHeartbeat(t *testing.T) {
+	start := time.Now()
+	g := NewPublishGate(PublishPolicy{AbsDeadband: 1, Heartbeat: time.Minute}, 0)
+
+	_, ok := g.Flush(start)
+	assert.False(t, ok, "nothing published yet")
+	assert.True(t, g.ShouldPublish("10", start))
+	assert.False(t, g.ShouldPublish("10.5", start.Add(time.Second)))
+
+	deadline, ok := g.NextDeadline()
+	assert.True(t, ok)
+	assert.Equal(t, start.Add(time.Minute), deadline)
+	v, ok := g.Flush(deadline)
+	assert.True(t, ok)
+	assert.Equal(t, "10", v, "last published value is repeated")
+
+	deadline, _ = g.NextDeadline()
+	assert.Equal(t, start.Add(2*time.Minute), deadline)
+}
+
+func TestPublishGateSetPolicy(t *testing.T) {
+	now := time.Now()
+	g := NewPublishGate(PublishPolicy{AbsDeadband: 1}, 0)
+	assert.True(t, g.ShouldPublish("10", now))
+	assert.False(t, g.ShouldPublish("10.5", now))
+
+	g.SetPolicy(PublishPolicy{AbsDeadband: 5}, 0)
+	assert.True(t, g.ShouldPublish("10.5", now), "first value after SetPolicy is forced")
+	assert.False(t, g.ShouldPublish("14", now))
+	assert.True(t, g.ShouldPublish("16", now))
+}
@@ -0,0 +1,317 @@
+package wbgong
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReadTx is an immutable consistent view of devices and controls known to driver.
+// It's safe to use from any goroutine and doesn't block driver loop.
+// Returned snapshots are shared between all readers of view and must not be modified,
+// copy them (including Meta and Controls) before modification
+type ReadTx interface {
+	// Version is incremented on each driver state change
+	Version() uint64
+
+	// Time returns time of the last state change included in view
+	Time() time.Time
+
+	// GetDevicesList returns devices sorted by ID
+	GetDevicesList() []*DeviceSnapshot
+
+	// GetDevice returns device by ID or nil
+	GetDevice(id string) *DeviceSnapshot
+
+	// HasDevice checks whether device with given ID exists
+	HasDevice(id string) bool
+
+	// GetControl returns control by device and control IDs or nil
+	GetControl(deviceId, controlId string) *ControlSnapshot
+
+	// GetRawValue returns control value, ok is false if there is no such control
+	GetRawValue(deviceId, controlId string) (value string, ok bool)
+
+	// Snapshot returns view as snapshot document (see WriteSnapshot)
+	Snapshot() *DriverSnapshot
+}
+
+type readView struct {
+	version uint64
+	time    time.Time
+	devices map[string]*DeviceSnapshot
+	ids     []string
+}
+
+func (v *readView) Version() uint64 {
+	return v.version
+}
+
+func (v *readView) Time() time.Time {
+	return v.time
+}
+
+func (v *readView) GetDevicesList() []*DeviceSnapshot {
+	res := make([]*DeviceSnapshot, 0, len(v.ids))
+	for _, id := range v.ids {
+		res = append(res, v.devices[id])
+	}
+	return res
+}
+
+func (v *readView) GetDevice(id string) *DeviceSnapshot {
+	return v.devices[id]
+}
+
+func (v *readView) HasDevice(id string) bool {
+	_, ok := v.devices[id]
+	return ok
+}
+
+func (v *readView) GetControl(deviceId, controlId string) *ControlSnapshot {
+	dev, ok := v.devices[deviceId]
+	if !ok {
+		return nil
+	}
+	if i := findControlSnapshot(dev.Controls, controlId); i >= 0 {
+		return &dev.Controls[i]
+	}
+	return nil
+}
+
+func (v *readView) GetRawValue(deviceId, controlId string) (string, bool) {
+	dev, ok := v.devices[deviceId]
+	if !ok {
+		return "", false
+	}
+	if i := findControlSnapshot(dev.Controls, controlId); i >= 0 {
+		return dev.Controls[i].RawValue, true
+	}
+	return "", false
+}
+
+func (v *readView) Snapshot() *DriverSnapshot {
+	s := &DriverSnapshot{
+		Version: DriverSnapshotVersion,
+		Time:    v.time,
+		Devices: make([]DeviceSnapshot, 0, len(v.ids)),
+	}
+	for _, id := range v.ids {
+		s.Devices = append(s.Devices, *v.devices[id])
+	}
+	return s
+}
+
+func findControlSnapshot(controls []ControlSnapshot, id string) int {
+	for i := range controls {
+		if controls[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ReadState keeps copy-on-write state of driver for ReadTx.
+// Driver loop updates state after each change, readers get current immutable view
+// by Load without any locking.
+// Each change copies devices index and changed device only,
+// updates which don't change anything copy nothing and keep version
+type ReadState struct {
+	mu      sync.Mutex
+	current atomic.Pointer[readView]
+}
+
+// NewReadState creates empty state
+func NewReadState() *ReadState {
+	s := &ReadState{}
+	s.current.Store(&readView{devices: make(map[string]*DeviceSnapshot)})
+	return s
+}
+
+// Load returns current view
+func (s *ReadState) Load() ReadTx {
+	return s.current.Load()
+}
+
+// deviceIndex is a copy-on-write devices map of view being built:
+// current map is copied on first modification only
+type deviceIndex struct {
+	devices map[string]*DeviceSnapshot
+	copied  bool
+}
+
+func (d *deviceIndex) get(id string) (*DeviceSnapshot, bool) {
+	dev, ok := d.devices[id]
+	return dev, ok
+}
+
+func (d *deviceIndex) write() map[string]*DeviceSnapshot {
+	if !d.copied {
+		devices := make(map[string]*DeviceSnapshot, len(d.devices)+1)
+		for id, dev := range d.devices {
+			devices[id] = dev
+		}
+		d.devices = devices
+		d.copied = true
+	}
+	return d.devices
+}
+
+// update creates new view from current one if f makes any change. Function may
+// replace devices in index, but must not modify existing device snapshots
+func (s *ReadState) update(f func(idx *deviceIndex)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur := s.current.Load()
+	idx := &deviceIndex{devices: cur.devices}
+	f(idx)
+	if !idx.copied {
+		return
+	}
+	next := &readView{
+		version: cur.version + 1,
+		time:    time.Now(),
+		devices: idx.devices,
+		ids:     cur.ids,
+	}
+	if !sameDeviceIds(cur.ids, idx.devices) {
+		next.ids = sortedKeys(idx.devices)
+	}
+	s.current.Store(next)
+}
+
+// updateDevice replaces device with modified copy. Device is copied
+// only if changed reports that apply will modify it
+func (s *ReadState) updateDevice(deviceId string, changed func(dev *DeviceSnapshot) bool, apply func(dev *DeviceSnapshot)) {
+	s.update(func(idx *deviceIndex) {
+		cur, ok := idx.get(deviceId)
+		if !ok || !changed(cur) {
+			return
+		}
+		dev := *cur
+		dev.Controls = append([]ControlSnapshot(nil), cur.Controls...)
+		apply(&dev)
+		idx.write()[deviceId] = &dev
+	})
+}
+
+// Reset replaces whole state with snapshot contents
+func (s *ReadState) Reset(snapshot *DriverSnapshot) {
+	s.update(func(idx *deviceIndex) {
+		devices := make(map[string]*DeviceSnapshot, len(snapshot.Devices))
+		for _, ds := range snapshot.Devices {
+			devices[ds.Id] = cloneDeviceSnapshot(ds)
+		}
+		idx.devices, idx.copied = devices, true
+	})
+}
+
+// SyncDevice adds or replaces device with its current state.
+// Must be called in driver loop
+func (s *ReadState) SyncDevice(dev Device) {
+	s.SetDevice(snapshotDevice(dev))
+}
+
+// SetDevice adds or replaces device
+func (s *ReadState) SetDevice(ds DeviceSnapshot) {
+	s.update(func(idx *deviceIndex) {
+		if cur, ok := idx.get(ds.Id); ok && deviceSnapshotsEqual(cur, &ds) {
+			return
+		}
+		idx.write()[ds.Id] = cloneDeviceSnapshot(ds)
+	})
+}
+
+// RemoveDevice removes device by ID
+func (s *ReadState) RemoveDevice(id string) {
+	s.update(func(idx *deviceIndex) {
+		if _, ok := idx.get(id); ok {
+			delete(idx.write(), id)
+		}
+	})
+}
+
+// SyncControl adds or replaces control with its current state.
+// Must be called in driver loop
+func (s *ReadState) SyncControl(ctrl Control) {
+	s.SetControl(ctrl.GetDevice().GetId(), ControlSnapshot{
+		Id:       ctrl.GetId(),
+		Meta:     ctrl.GetMetaJson(),
+		RawValue: ctrl.GetRawValue(),
+	})
+}
+
+// SetControl adds or replaces control of existing device
+func (s *ReadState) SetControl(deviceId string, cs ControlSnapshot) {
+	cs.Meta = cs.Meta.Clone()
+	s.updateDevice(deviceId, func(dev *DeviceSnapshot) bool {
+		i := findControlSnapshot(dev.Controls, cs.Id)
+		return i < 0 || !controlSnapshotsEqual(&dev.Controls[i], &cs)
+	}, func(dev *DeviceSnapshot) {
+		if i := findControlSnapshot(dev.Controls, cs.Id); i >= 0 {
+			dev.Controls[i] = cs
+		} else {
+			dev.Controls = append(dev.Controls, cs)
+		}
+	})
+}
+
+// SetControlValue updates value of existing control
+func (s *ReadState) SetControlValue(deviceId, controlId, rawValue string) {
+	s.updateDevice(deviceId, func(dev *DeviceSnapshot) bool {
+		i := findControlSnapshot(dev.Controls, controlId)
+		return i >= 0 && dev.Controls[i].RawValue != rawValue
+	}, func(dev *DeviceSnapshot) {
+		dev.Controls[findControlSnapshot(dev.Controls, controlId)].RawValue = rawValue
+	})
+}
+
+// RemoveControl removes control from device
+func (s *ReadState) RemoveControl(deviceId, controlId string) {
+	s.updateDevice(deviceId, func(dev *DeviceSnapshot) bool {
+		return findControlSnapshot(dev.Controls, controlId) >= 0
+	}, func(dev *DeviceSnapshot) {
+		i := findControlSnapshot(dev.Controls, controlId)
+		dev.Controls = append(dev.Controls[:i], dev.Controls[i+1:]...)
+	})
+}
+
+func sameDeviceIds(ids []string, devices map[string]*DeviceSnapshot) bool {
+	if len(ids) != len(devices) {
+		return false
+	}
+	for _, id := range ids {
+		if _, ok := devices[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func controlSnapshotsEqual(a, b *ControlSnapshot) bool {
+	return a.Id == b.Id && a.RawValue == b.RawValue && a.Meta.Equal(b.Meta)
+}
+
+func deviceSnapshotsEqual(a, b *DeviceSnapshot) bool {
+	if a.Id != b.Id || a.Local != b.Local || a.Virtual != b.Virtual ||
+		a.LoadPrevious != b.LoadPrevious || len(a.Controls) != len(b.Controls) || !a.Meta.Equal(b.Meta) {
+		return false
+	}
+	for i := range a.Controls {
+		if !controlSnapshotsEqual(&a.Controls[i], &b.Controls[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func cloneDeviceSnapshot(ds DeviceSnapshot) *DeviceSnapshot {
+	res := ds
+	res.Meta = ds.Meta.Clone()
+	res.Controls = make([]ControlSnapshot, len(ds.Controls))
+	for i, cs := range ds.Controls {
+		res.Controls[i] = cs
+		res.Controls[i].Meta = cs.Meta.Clone()
+	}
+	return &res
+}
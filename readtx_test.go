@@ -0,0 +1,83 @@
+package wbgong
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadStateVersions(t *testing.T) {
+	s := NewReadState()
+	assert.Equal(t, uint64(0), s.Load().Version())
+
+	dev := DeviceSnapshot{
+		Id:       "dev",
+		Local:    true,
+		Meta:     MetaInfo{"title": Title{"en": "Device"}},
+		Controls: []ControlSnapshot{{Id: "K1", Meta: MetaInfo{"type": "switch", "order": 1}, RawValue: "0"}},
+	}
+	s.SetDevice(dev)
+	v1 := s.Load()
+	assert.Equal(t, uint64(1), v1.Version())
+
+	// no-op updates keep current view
+	s.SetDevice(dev)
+	s.SetControl("dev", ControlSnapshot{Id: "K1", Meta: MetaInfo{"type": "switch", "order": 1.0}, RawValue: "0"})
+	s.SetControlValue("dev", "K1", "0")
+	s.SetControlValue("dev", "missing", "1")
+	s.SetControlValue("missing", "K1", "1")
+	s.RemoveControl("dev", "missing")
+	s.RemoveDevice("missing")
+	assert.Same(t, v1, s.Load())
+
+	s.SetControlValue("dev", "K1", "1")
+	v2 := s.Load()
+	assert.Equal(t, uint64(2), v2.Version())
+	value, ok := v2.GetRawValue("dev", "K1")
+	assert.True(t, ok)
+	assert.Equal(t, "1", value)
+	value, _ = v1.GetRawValue("dev", "K1")
+	assert.Equal(t, "0", value, "old view is not changed")
+
+	s.SetControl("dev", ControlSnapshot{Id: "K2", Meta: MetaInfo{"type": "value"}, RawValue: "5"})
+	s.SetControl("dev", ControlSnapshot{Id: "K2", Meta: MetaInfo{"type": "value", "units": "W"}, RawValue: "5"})
+	assert.Equal(t, uint64(4), s.Load().Version())
+	assert.Equal(t, "W", s.Load().GetControl("dev", "K2").Meta["units"])
+
+	s.RemoveControl("dev", "K2")
+	s.RemoveDevice("dev")
+	removed := s.Load()
+	assert.Equal(t, uint64(6), removed.Version())
+	assert.False(t, removed.HasDevice("dev"))
+	assert.Empty(t, removed.GetDevicesList())
+	assert.True(t, v2.HasDevice("dev"))
+}
+
+func TestReadStateSharedSnapshots(t *testing.T) {
+	s := NewReadState()
+	s.SetDevice(DeviceSnapshot{Id: "b", Controls: []ControlSnapshot{{Id: "x", RawValue: "1"}}})
+	s.SetDevice(DeviceSnapshot{Id: "a"})
+	s.SetDevice(DeviceSnapshot{Id: "c"})
+	v := s.Load()
+
+	list := v.GetDevicesList()
+	require.Len(t, list, 3)
+	assert.Equal(t, []string{"a", "b", "c"}, []string{list[0].Id, list[1].Id, list[2].Id})
+	assert.Same(t, list[1], v.GetDevice("b"), "readers share device snapshots")
+	assert.Same(t, &list[1].Controls[0], v.GetControl("b", "x"))
+	assert.Nil(t, v.GetDevice("missing"))
+	assert.Nil(t, v.GetControl("b", "missing"))
+
+	// unchanged devices are shared between views too
+	s.SetControlValue("b", "x", "2")
+	next := s.Load()
+	assert.Same(t, v.GetDevice("a"), next.GetDevice("a"))
+	assert.NotSame(t, v.GetDevice("b"), next.GetDevice("b"))
+
+	snapshot := next.Snapshot()
+	assert.Equal(t, DriverSnapshotVersion, snapshot.Version)
+	assert.Equal(t, next.Time(), snapshot.Time)
+	require.Len(t, snapshot.Devices, 3)
+	assert.Equal(t, "2", snapshot.Devices[1].Controls[0].RawValue)
+}
@@ -0,0 +1,234 @@
+package wbgong
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DriverSnapshotVersion is a current version of snapshot document format
+const DriverSnapshotVersion = 1
+
+// ControlSnapshot is a serialized control state
+type ControlSnapshot struct {
+	Id       string   `json:"id"`
+	Meta     MetaInfo `json:"meta"`
+	RawValue string   `json:"value"`
+}
+
+// DeviceSnapshot is a serialized device state
+type DeviceSnapshot struct {
+	Id           string            `json:"id"`
+	Local        bool              `json:"local"`
+	Virtual      bool              `json:"virtual,omitempty"`
+	LoadPrevious bool              `json:"load_previous,omitempty"`
+	Meta         MetaInfo          `json:"meta"`
+	Controls     []ControlSnapshot `json:"controls"`
+}
+
+// DriverSnapshot is a versioned document with all devices known to driver
+type DriverSnapshot struct {
+	Version int              `json:"version"`
+	Time    time.Time        `json:"time"`
+	Devices []DeviceSnapshot `json:"devices"`
+}
+
+// RestoreOptions controls Restore behaviour
+type RestoreOptions struct {
+	// Publish control values from snapshot, otherwise controls
+	// get default (or previous, if storage is used) values
+	PublishValues bool
+
+	// Remove existing local devices with the same IDs,
+	// otherwise DeviceAlreadyExistsError is returned for them
+	Replace bool
+}
+
+// Snapshot collects state of all local and external devices known to driver
+func Snapshot(tx DriverTx) *DriverSnapshot {
+	devices := tx.GetDevicesList()
+	s := &DriverSnapshot{
+		Version: DriverSnapshotVersion,
+		Time:    time.Now(),
+		Devices: make([]DeviceSnapshot, 0, len(devices)),
+	}
+	for _, dev := range devices {
+		if dev.IsDeleted() {
+			continue
+		}
+		s.Devices = append(s.Devices, snapshotDevice(dev))
+	}
+	return s
+}
+
+func snapshotDevice(dev Device) DeviceSnapshot {
+	controls := dev.ControlsList()
+	ds := DeviceSnapshot{
+		Id:       dev.GetId(),
+		Meta:     dev.GetMetaJson(),
+		Controls: make([]ControlSnapshot, 0, len(controls)),
+	}
+	if local, ok := dev.(LocalDevice); ok {
+		ds.Local = true
+		ds.Virtual = local.IsVirtual()
+		ds.LoadPrevious = local.DoLoadPrevious()
+	}
+	for _, ctrl := range controls {
+		if ctrl.IsDeleted() {
+			continue
+		}
+		ds.Controls = append(ds.Controls, ControlSnapshot{
+			Id:       ctrl.GetId(),
+			Meta:     ctrl.GetMetaJson(),
+			RawValue: ctrl.GetRawValue(),
+		})
+	}
+	return ds
+}
+
+// WriteSnapshot writes snapshot as JSON document
+func WriteSnapshot(w io.Writer, s *DriverSnapshot) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	return nil
+}
+
+// ReadSnapshot reads snapshot JSON document and checks its version
+func ReadSnapshot(r io.Reader) (*DriverSnapshot, error) {
+	s := &DriverSnapshot{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	if s.Version < 1 || s.Version > DriverSnapshotVersion {
+		return nil, fmt.Errorf("%w: %d", UnsupportedSnapshotVersionError, s.Version)
+	}
+	return s, nil
+}
+
+// Restore recreates local devices from snapshot. External devices are skipped
+// as they are owned by other drivers.
+// Whole snapshot is validated before any change is made. If some device
+// can't be created, devices created so far are removed and replaced ones are restored
+func Restore(tx DriverTx, s *DriverSnapshot, opts RestoreOptions) error {
+	specs := make([]DeviceSpec, 0, len(s.Devices))
+	for _, ds := range s.Devices {
+		if !ds.Local {
+			continue
+		}
+		if tx.HasDevice(ds.Id) && !opts.Replace {
+			return fmt.Errorf("%w: %s", DeviceAlreadyExistsError, ds.Id)
+		}
+		spec, err := ds.Spec(opts.PublishValues)
+		if err != nil {
+			return err
+		}
+		specs = append(specs, spec)
+	}
+
+	var created []LocalDevice
+	var replaced []DeviceSnapshot
+	rollback := func() {
+		removeDevices(tx, created)
+		for _, ds := range replaced {
+			spec, err := ds.Spec(true)
+			if err == nil {
+				_, err = createDeviceFromSpec(tx, spec)
+			}
+			if err != nil {
+				Error.Printf("failed to restore replaced device %s: %v", ds.Id, err)
+			}
+		}
+	}
+	for _, spec := range specs {
+		if old := tx.GetDevice(spec.Id); old != nil {
+			snapshot := snapshotDevice(old)
+			if err := tx.RemoveDeviceById(spec.Id)(); err != nil {
+				rollback()
+				return fmt.Errorf("failed to remove device %s: %w", spec.Id, err)
+			}
+			replaced = append(replaced, snapshot)
+		}
+		dev, err := createDeviceFromSpec(tx, spec)
+		if err != nil {
+			rollback()
+			return err
+		}
+		created = append(created, dev)
+	}
+	return nil
+}
+
+// Spec converts device snapshot to device description.
+// Control values are included if withValues is set
+func (ds *DeviceSnapshot) Spec(withValues bool) (DeviceSpec, error) {
+	spec := DeviceSpec{
+		Id:           ds.Id,
+		Virtual:      ds.Virtual,
+		LoadPrevious: ds.LoadPrevious,
+		ExtraMeta:    ExtractExtraMeta(ds.Meta, true),
+		Controls:     make([]ControlSpec, 0, len(ds.Controls)),
+	}
+	var err error
+	if spec.Title, err = metaTitle(ds.Meta[CONV_META_SUBTOPIC_TITLE_V2]); err != nil {
+		return spec, fmt.Errorf("device %s: %w", ds.Id, err)
+	}
+	for _, cs := range ds.Controls {
+		ctrl, err := controlSpecFromMetaJson(cs.Id, cs.Meta)
+		if err != nil {
+			return spec, fmt.Errorf("control %s/%s: %w", ds.Id, cs.Id, err)
+		}
+		if withValues {
+			value := cs.RawValue
+			ctrl.Value = &value
+		}
+		spec.Controls = append(spec.Controls, ctrl)
+	}
+	return spec, nil
+}
+
+// controlSpecFromMetaJson converts control meta v2 object to control description
+func controlSpecFromMetaJson(id string, meta MetaInfo) (spec ControlSpec, err error) {
+	spec.Id = id
+	spec.ExtraMeta = ExtractExtraMeta(meta, false)
+	for key, value := range meta {
+		switch key {
+		case CONV_META_SUBTOPIC_TYPE:
+			spec.Type = fmt.Sprint(value)
+		case CONV_META_SUBTOPIC_CONTROL_TITLE:
+			spec.Title, err = metaTitle(value)
+		case CONV_META_SUBTOPIC_DESCRIPTION:
+			s := fmt.Sprint(value)
+			spec.Description = &s
+		case CONV_META_SUBTOPIC_UNITS:
+			s := fmt.Sprint(value)
+			spec.Units = &s
+		case CONV_META_SUBTOPIC_READONLY:
+			spec.Readonly, err = ptr(metaBool(value))
+		case CONV_META_SUBTOPIC_MIN:
+			spec.Min, err = ptr(metaFloat(value))
+		case CONV_META_SUBTOPIC_MAX:
+			spec.Max, err = ptr(metaFloat(value))
+		case CONV_META_SUBTOPIC_PRECISION:
+			spec.Precision, err = ptr(metaFloat(value))
+		case CONV_META_SUBTOPIC_ORDER:
+			var f float64
+			if f, err = metaFloat(value); err == nil {
+				order := int(f)
+				spec.Order = &order
+			}
+		case CONV_META_SUBTOPIC_CONTROL_ENUM:
+			spec.EnumTitles, err = metaEnum(value)
+		}
+		if err != nil {
+			return spec, fmt.Errorf("meta %s: %w", key, err)
+		}
+	}
+	if spec.Type == "" {
+		return spec, ControlArgsMissingError
+	}
+	return spec, nil
+}
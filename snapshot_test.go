@@ -0,0 +1,299 @@
+package wbgong
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Minimal in-memory implementations of args, devices, controls and DriverTx.
+// Only methods used by Snapshot and Restore are implemented
+
+type testDeviceArgs struct {
+	LocalDeviceArgs
+	id           string
+	virtual      bool
+	loadPrevious bool
+	meta         MetaInfo
+}
+
+func (a *testDeviceArgs) SetId(v string) LocalDeviceArgs           { a.id = v; return a }
+func (a *testDeviceArgs) SetVirtual(v bool) LocalDeviceArgs        { a.virtual = v; return a }
+func (a *testDeviceArgs) SetDoLoadPrevious(v bool) LocalDeviceArgs { a.loadPrevious = v; return a }
+func (a *testDeviceArgs) SetTitle(v Title) LocalDeviceArgs {
+	a.meta[CONV_META_SUBTOPIC_TITLE_V2] = v
+	return a
+}
+func (a *testDeviceArgs) SetExtraMeta(key string, value any) LocalDeviceArgs {
+	a.meta[key] = value
+	return a
+}
+
+type testControlArgs struct {
+	ControlArgs
+	id    string
+	value string
+	meta  MetaInfo
+}
+
+func (a *testControlArgs) set(key string, value any) ControlArgs {
+	a.meta[key] = value
+	return a
+}
+
+func (a *testControlArgs) SetId(v string) ControlArgs       { a.id = v; return a }
+func (a *testControlArgs) SetRawValue(v string) ControlArgs { a.value = v; return a }
+func (a *testControlArgs) SetDoLoadPrevious(bool) ControlArgs {
+	return a
+}
+func (a *testControlArgs) SetLazyInit(bool) ControlArgs { return a }
+func (a *testControlArgs) SetType(v string) ControlArgs {
+	return a.set(CONV_META_SUBTOPIC_TYPE, v)
+}
+func (a *testControlArgs) SetTitle(v Title) ControlArgs {
+	return a.set(CONV_META_SUBTOPIC_CONTROL_TITLE, v)
+}
+func (a *testControlArgs) SetDescription(v string) ControlArgs {
+	return a.set(CONV_META_SUBTOPIC_DESCRIPTION, v)
+}
+func (a *testControlArgs) SetUnits(v string) ControlArgs {
+	return a.set(CONV_META_SUBTOPIC_UNITS, v)
+}
+func (a *testControlArgs) SetReadonly(v bool) ControlArgs {
+	return a.set(CONV_META_SUBTOPIC_READONLY, v)
+}
+func (a *testControlArgs) SetMin(v float64) ControlArgs {
+	return a.set(CONV_META_SUBTOPIC_MIN, v)
+}
+func (a *testControlArgs) SetMax(v float64) ControlArgs {
+	return a.set(CONV_META_SUBTOPIC_MAX, v)
+}
+func (a *testControlArgs) SetPrecision(v float64) ControlArgs {
+	return a.set(CONV_META_SUBTOPIC_PRECISION, v)
+}
+func (a *testControlArgs) SetOrder(v int) ControlArgs {
+	return a.set(CONV_META_SUBTOPIC_ORDER, v)
+}
+func (a *testControlArgs) SetEnumTitles(v map[string]Title) ControlArgs {
+	return a.set(CONV_META_SUBTOPIC_CONTROL_ENUM, v)
+}
+func (a *testControlArgs) SetExtraMeta(key string, value any) ControlArgs {
+	return a.set(key, value)
+}
+
+type testControl struct {
+	Control
+	id    string
+	value string
+	meta  MetaInfo
+}
+
+func (c *testControl) GetId() string         { return c.id }
+func (c *testControl) IsDeleted() bool       { return false }
+func (c *testControl) GetRawValue() string   { return c.value }
+func (c *testControl) GetMetaJson() MetaInfo { return c.meta }
+
+type testLocalDevice struct {
+	LocalDevice
+	args     *testDeviceArgs
+	controls []Control
+	// fails creation of control with given id
+	failControl string
+}
+
+func (d *testLocalDevice) GetId() string           { return d.args.id }
+func (d *testLocalDevice) IsDeleted() bool         { return false }
+func (d *testLocalDevice) IsVirtual() bool         { return d.args.virtual }
+func (d *testLocalDevice) DoLoadPrevious() bool    { return d.args.loadPrevious }
+func (d *testLocalDevice) GetMetaJson() MetaInfo   { return d.args.meta }
+func (d *testLocalDevice) ControlsList() []Control { return d.controls }
+
+func (d *testLocalDevice) CreateControl(args ControlArgs) func() (Control, error) {
+	a := args.(*testControlArgs)
+	return func() (Control, error) {
+		if a.id == d.failControl {
+			return nil, errors.New("test failure")
+		}
+		ctrl := &testControl{id: a.id, value: a.value, meta: a.meta}
+		d.controls = append(d.controls, ctrl)
+		return ctrl, nil
+	}
+}
+
+type testSnapshotTx struct {
+	DriverTx
+	devices     []*testLocalDevice
+	failControl string
+}
+
+func (tx *testSnapshotTx) find(id string) int {
+	for i, dev := range tx.devices {
+		if dev.GetId() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (tx *testSnapshotTx) GetDevicesList() []Device {
+	res := make([]Device, len(tx.devices))
+	for i, dev := range tx.devices {
+		res[i] = dev
+	}
+	return res
+}
+
+func (tx *testSnapshotTx) HasDevice(id string) bool {
+	return tx.find(id) >= 0
+}
+
+func (tx *testSnapshotTx) GetDevice(id string) Device {
+	if i := tx.find(id); i >= 0 {
+		return tx.devices[i]
+	}
+	return nil
+}
+
+func (tx *testSnapshotTx) CreateDevice(args LocalDeviceArgs) func() (LocalDevice, error) {
+	return func() (LocalDevice, error) {
+		a := args.(*testDeviceArgs)
+		if tx.HasDevice(a.id) {
+			return nil, DeviceAlreadyExistsError
+		}
+		dev := &testLocalDevice{args: a, failControl: tx.failControl}
+		tx.devices = append(tx.devices, dev)
+		return dev, nil
+	}
+}
+
+func (tx *testSnapshotTx) RemoveDeviceById(id string) func() error {
+	return func() error {
+		i := tx.find(id)
+		if i < 0 {
+			return errors.New("no such device")
+		}
+		tx.devices = append(tx.devices[:i:i], tx.devices[i+1:]...)
+		return nil
+	}
+}
+
+func (tx *testSnapshotTx) RemoveDevice(dev LocalDevice) func() error {
+	return tx.RemoveDeviceById(dev.GetId())
+}
+
+func withTestArgs(t *testing.T) {
+	funcNewLocalDeviceArgs = func() LocalDeviceArgs {
+		return &testDeviceArgs{meta: make(MetaInfo)}
+	}
+	funcNewControlArgs = func() ControlArgs {
+		return &testControlArgs{meta: make(MetaInfo)}
+	}
+	t.Cleanup(func() {
+		funcNewLocalDeviceArgs = nil
+		funcNewControlArgs = nil
+	})
+}
+
+const testSnapshot = `{
+  "version": 1,
+  "time": "2026-01-01T00:00:00Z",
+  "devices": [
+    {
+      "id": "dev1",
+      "local": true,
+      "virtual": true,
+      "meta": {"title": {"en": "Device 1"}, "serial": "42"},
+      "controls": [
+        {"id": "K1", "meta": {"type": "switch", "readonly": false, "order": 1, "title": {"en": "Relay"}}, "value": "1"},
+        {"id": "T", "meta": {"type": "temperature", "readonly": true, "precision": 0.1, "units": "deg C"}, "value": "21.5"}
+      ]
+    },
+    {
+      "id": "ext",
+      "local": false,
+      "meta": {},
+      "controls": [{"id": "x", "meta": {"type": "value"}, "value": "1"}]
+    }
+  ]
+}`
+
+// devicesJson returns snapshot devices as JSON, so snapshots taken at different time can be compared
+func devicesJson(t *testing.T, s *DriverSnapshot) string {
+	var buf bytes.Buffer
+	require.NoError(t, WriteSnapshot(&buf, &DriverSnapshot{Version: s.Version, Devices: s.Devices}))
+	return buf.String()
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	withTestArgs(t)
+	s, err := ReadSnapshot(bytes.NewBufferString(testSnapshot))
+	require.NoError(t, err)
+
+	tx := &testSnapshotTx{}
+	require.NoError(t, Restore(tx, s, RestoreOptions{PublishValues: true}))
+	require.Len(t, tx.devices, 1, "external devices are skipped")
+
+	s.Devices = s.Devices[:1]
+	assert.JSONEq(t, devicesJson(t, s), devicesJson(t, Snapshot(tx)))
+
+	// without values controls get default values
+	tx = &testSnapshotTx{}
+	require.NoError(t, Restore(tx, s, RestoreOptions{}))
+	assert.Equal(t, "", tx.devices[0].controls[0].GetRawValue())
+
+	// existing devices are not replaced by default
+	err = Restore(tx, s, RestoreOptions{})
+	assert.ErrorIs(t, err, DeviceAlreadyExistsError)
+	require.NoError(t, Restore(tx, s, RestoreOptions{Replace: true, PublishValues: true}))
+	assert.Equal(t, "1", tx.devices[0].controls[0].GetRawValue())
+}
+
+func TestRestoreRollback(t *testing.T) {
+	withTestArgs(t)
+	s, err := ReadSnapshot(bytes.NewBufferString(testSnapshot))
+	require.NoError(t, err)
+	s.Devices[1].Local = true
+	s.Devices[1].Id = "dev2"
+
+	tx := &testSnapshotTx{}
+	require.NoError(t, Restore(tx, &DriverSnapshot{Version: 1, Devices: s.Devices[:1]}, RestoreOptions{PublishValues: true}))
+	before := devicesJson(t, Snapshot(tx))
+
+	// second device fails, first one is restored to its previous state
+	tx.failControl = "x"
+	err = Restore(tx, s, RestoreOptions{Replace: true})
+	assert.Error(t, err)
+	require.Len(t, tx.devices, 1)
+	assert.Equal(t, "dev1", tx.devices[0].GetId())
+	assert.JSONEq(t, before, devicesJson(t, Snapshot(tx)))
+}
+
+func TestRestoreValidation(t *testing.T) {
+	withTestArgs(t)
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"bad version", `{"version": 2, "devices": []}`, UnsupportedSnapshotVersionError},
+		{"no version", `{"devices": []}`, UnsupportedSnapshotVersionError},
+		{"missing type", `{"version": 1, "devices": [{"id": "a", "local": true, "meta": {},
+			"controls": [{"id": "c", "meta": {}}]}]}`, ControlArgsMissingError},
+		{"bad title", `{"version": 1, "devices": [{"id": "a", "local": true, "meta": {"title": 1}}]}`,
+			WrongValueTypeError},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := ReadSnapshot(bytes.NewBufferString(tc.data))
+			if err == nil {
+				tx := &testSnapshotTx{}
+				err = Restore(tx, s, RestoreOptions{})
+				assert.Empty(t, tx.devices, "nothing is created on validation failure")
+			}
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package wbgong
+
+import (
+	"sync"
+)
+
+// Storage keeps control values between driver restarts
+// (see LocalDeviceArgs.SetDoLoadPrevious).
+// Storage implementations must be thread-safe
+type Storage interface {
+	// Get returns stored value or StorageValueNotFoundError
+	Get(deviceId, controlId string) (string, error)
+
+	// Put stores control value
+	Put(deviceId, controlId, value string) error
+
+	// Delete removes control value. Empty controlId removes all device values
+	Delete(deviceId, controlId string) error
+
+	// Iterate calls f for all values of device sorted by control ID.
+	// Empty deviceId iterates over all devices.
+	// Iteration stops if f returns false
+	Iterate(deviceId string, f func(deviceId, controlId, value string) bool) error
+
+	// Close flushes pending changes and releases resources
+	Close() error
+}
+
+// MemoryStorage keeps values in memory only, it's useful for tests
+type MemoryStorage struct {
+	mu     sync.RWMutex
+	values map[string]map[string]string
+	count  int
+}
+
+// NewMemoryStorage creates empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{values: make(map[string]map[string]string)}
+}
+
+func (s *MemoryStorage) Get(deviceId, controlId string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if value, ok := s.values[deviceId][controlId]; ok {
+		return value, nil
+	}
+	return "", StorageValueNotFoundError
+}
+
+func (s *MemoryStorage) Put(deviceId, controlId, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(deviceId, controlId, value)
+	return nil
+}
+
+func (s *MemoryStorage) put(deviceId, controlId, value string) {
+	controls, ok := s.values[deviceId]
+	if !ok {
+		controls = make(map[string]string)
+		s.values[deviceId] = controls
+	}
+	if _, ok := controls[controlId]; !ok {
+		s.count++
+	}
+	controls[controlId] = value
+}
+
+func (s *MemoryStorage) Delete(deviceId, controlId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delete(deviceId, controlId)
+	return nil
+}
+
+func (s *MemoryStorage) delete(deviceId, controlId string) {
+	if controlId == "" {
+		s.count -= len(s.values[deviceId])
+		delete(s.values, deviceId)
+		return
+	}
+	if controls, ok := s.values[deviceId]; ok {
+		if _, ok := controls[controlId]; ok {
+			s.count--
+		}
+		delete(controls, controlId)
+		if len(controls) == 0 {
+			delete(s.values, deviceId)
+		}
+	}
+}
+
+func (s *MemoryStorage) has(deviceId, controlId, value string) bool {
+	v, ok := s.values[deviceId][controlId]
+	return ok && v == value
+}
+
+// Iterate calls f for values copied under lock, so f may modify storage
+func (s *MemoryStorage) Iterate(deviceId string, f func(deviceId, controlId, value string) bool) error {
+	var entries []StorageOp
+	s.mu.RLock()
+	s.iterate(deviceId, func(deviceId, controlId, value string) bool {
+		entries = append(entries, StorageOp{DeviceId: deviceId, ControlId: controlId, Value: value})
+		return true
+	})
+	s.mu.RUnlock()
+	for _, e := range entries {
+		if !f(e.DeviceId, e.ControlId, e.Value) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) iterate(deviceId string, f func(deviceId, controlId, value string) bool) bool {
+	devices := []string{deviceId}
+	if deviceId == "" {
+		devices = sortedKeys(s.values)
+	}
+	for _, dev := range devices {
+		controls := s.values[dev]
+		for _, ctrl := range sortedKeys(controls) {
+			if !f(dev, ctrl, controls[ctrl]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Len returns number of stored values
+func (s *MemoryStorage) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.count
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
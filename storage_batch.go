@@ -0,0 +1,215 @@
+package wbgong
+
+import (
+	"sync"
+	"time"
+)
+
+type storageKey struct {
+	deviceId  string
+	controlId string
+}
+
+// BatchingStorage collects changes in memory and writes them
+// to underlying storage once per interval, reducing flash wear.
+// Changes made within interval before power loss are lost.
+// If underlying storage implements StorageBatchWriter, all pending changes
+// are written with single call
+type BatchingStorage struct {
+	mu sync.Mutex
+	// flushMu serializes flushes, so changes are written in order
+	flushMu  sync.Mutex
+	storage  Storage
+	pending  map[storageKey]StorageOp
+	order    []storageKey
+	interval time.Duration
+	quit     chan struct{}
+	done     chan struct{}
+	closed   bool
+}
+
+// NewBatchingStorage wraps storage and starts periodic flushing.
+// Non-positive interval disables batching: every change is written immediately
+func NewBatchingStorage(storage Storage, interval time.Duration) *BatchingStorage {
+	s := &BatchingStorage{
+		storage:  storage,
+		pending:  make(map[storageKey]StorageOp),
+		interval: interval,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if interval <= 0 {
+		close(s.done)
+		return s
+	}
+	go s.run()
+	return s
+}
+
+func (s *BatchingStorage) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				Error.Printf("failed to flush storage: %v", err)
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *BatchingStorage) Get(deviceId, controlId string) (string, error) {
+	s.mu.Lock()
+	op, ok := s.pending[storageKey{deviceId, controlId}]
+	s.mu.Unlock()
+	if ok {
+		if op.Delete {
+			return "", StorageValueNotFoundError
+		}
+		return op.Value, nil
+	}
+	return s.storage.Get(deviceId, controlId)
+}
+
+func (s *BatchingStorage) Put(deviceId, controlId, value string) error {
+	s.mu.Lock()
+	s.stage(StorageOp{DeviceId: deviceId, ControlId: controlId, Value: value})
+	s.mu.Unlock()
+	return s.flushUnbatched()
+}
+
+// Delete stages control value removal. Removal of all device values
+// flushes pending changes and is performed immediately
+func (s *BatchingStorage) Delete(deviceId, controlId string) error {
+	if controlId == "" {
+		if err := s.Flush(); err != nil {
+			return err
+		}
+		return s.storage.Delete(deviceId, controlId)
+	}
+	s.mu.Lock()
+	s.stage(StorageOp{DeviceId: deviceId, ControlId: controlId, Delete: true})
+	s.mu.Unlock()
+	return s.flushUnbatched()
+}
+
+// flushUnbatched writes staged change immediately if batching is disabled
+func (s *BatchingStorage) flushUnbatched() error {
+	if s.interval > 0 {
+		return nil
+	}
+	return s.Flush()
+}
+
+func (s *BatchingStorage) stage(op StorageOp) {
+	key := storageKey{op.DeviceId, op.ControlId}
+	if _, ok := s.pending[key]; !ok {
+		s.order = append(s.order, key)
+	}
+	s.pending[key] = op
+}
+
+// Iterate flushes pending changes and iterates over underlying storage
+func (s *BatchingStorage) Iterate(deviceId string, f func(deviceId, controlId, value string) bool) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	return s.storage.Iterate(deviceId, f)
+}
+
+// Pending returns number of changes waiting for flush
+func (s *BatchingStorage) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// Flush writes pending changes to underlying storage.
+// Changes which failed to be written are queued again
+// unless they're already replaced by newer ones
+func (s *BatchingStorage) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	ops := make([]StorageOp, 0, len(s.order))
+	for _, key := range s.order {
+		ops = append(ops, s.pending[key])
+	}
+	s.pending = make(map[storageKey]StorageOp)
+	s.order = nil
+	s.mu.Unlock()
+
+	if len(ops) == 0 {
+		return nil
+	}
+	if w, ok := s.storage.(StorageBatchWriter); ok {
+		if err := w.WriteBatch(ops); err != nil {
+			s.requeue(ops)
+			return err
+		}
+		return nil
+	}
+	for i, op := range ops {
+		var err error
+		if op.Delete {
+			err = s.storage.Delete(op.DeviceId, op.ControlId)
+		} else {
+			err = s.storage.Put(op.DeviceId, op.ControlId, op.Value)
+		}
+		if err != nil {
+			s.requeue(ops[i:])
+			return err
+		}
+	}
+	return nil
+}
+
+// requeue returns failed changes to pending ones, they precede changes
+// staged during flush; newer changes of the same controls win
+func (s *BatchingStorage) requeue(ops []StorageOp) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order := make([]storageKey, 0, len(ops)+len(s.order))
+	for _, op := range ops {
+		key := storageKey{op.DeviceId, op.ControlId}
+		if _, ok := s.pending[key]; ok {
+			continue
+		}
+		s.pending[key] = op
+		order = append(order, key)
+	}
+	s.order = append(order, s.order...)
+}
+
+// Stats returns statistics of underlying storage
+// (empty if it doesn't implement StorageStatsProvider)
+func (s *BatchingStorage) Stats() StorageStats {
+	if p, ok := s.storage.(StorageStatsProvider); ok {
+		return p.Stats()
+	}
+	return StorageStats{}
+}
+
+// Close stops flushing, writes pending changes and closes underlying storage
+func (s *BatchingStorage) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.quit)
+	<-s.done
+	err := s.Flush()
+	if closeErr := s.storage.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,383 @@
+package wbgong
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StorageOp is a single storage change (see StorageBatchWriter)
+type StorageOp struct {
+	DeviceId  string
+	ControlId string
+	Value     string
+	// Delete removes value instead of storing it
+	Delete bool
+}
+
+// StorageBatchWriter is implemented by storages which can write
+// several changes at once (e.g. with single file write and sync)
+type StorageBatchWriter interface {
+	WriteBatch(ops []StorageOp) error
+}
+
+// StorageStats contains storage write statistics
+type StorageStats struct {
+	// Time when statistics collection started
+	Since time.Time
+	// Number of write operations to persistent media
+	Writes uint64
+	// Number of bytes written to persistent media
+	BytesWritten uint64
+	// Number of log compactions
+	Compactions uint64
+}
+
+// BytesPerDay estimates daily write volume
+func (s StorageStats) BytesPerDay() float64 {
+	elapsed := time.Since(s.Since)
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(s.BytesWritten) / elapsed.Hours() * 24
+}
+
+// StorageStatsProvider is implemented by storages which collect write statistics
+type StorageStatsProvider interface {
+	Stats() StorageStats
+}
+
+type storageStats struct {
+	statsMu sync.Mutex
+	stats   StorageStats
+}
+
+func (s *storageStats) init() {
+	s.stats.Since = time.Now()
+}
+
+func (s *storageStats) written(n int) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	s.stats.Writes++
+	s.stats.BytesWritten += uint64(n)
+}
+
+func (s *storageStats) compacted() {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	s.stats.Compactions++
+}
+
+func (s *storageStats) Stats() StorageStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.stats
+}
+
+func (s *MemoryStorage) apply(ops []StorageOp) bool {
+	changed := false
+	for _, op := range ops {
+		if op.Delete {
+			s.delete(op.DeviceId, op.ControlId)
+			changed = true
+		} else if !s.has(op.DeviceId, op.ControlId, op.Value) {
+			s.put(op.DeviceId, op.ControlId, op.Value)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// JSONFileStorage keeps all values in memory and rewrites
+// JSON file on each change atomically (via temporary file and rename).
+// File is human-readable, but each change rewrites whole file,
+// so use it with NewBatchingStorage for frequently changing values
+type JSONFileStorage struct {
+	MemoryStorage
+	storageStats
+	path string
+	mode os.FileMode
+	// dirty is set if values in memory failed to be written
+	dirty bool
+}
+
+// NewJSONFileStorage opens JSON file storage, file is created on first change
+func NewJSONFileStorage(path string, mode os.FileMode) (*JSONFileStorage, error) {
+	s := &JSONFileStorage{
+		MemoryStorage: MemoryStorage{values: make(map[string]map[string]string)},
+		path:          path,
+		mode:          mode,
+	}
+	s.storageStats.init()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", StorageUnavailableError, err)
+	}
+	if err := json.Unmarshal(data, &s.values); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse %s: %v", StorageUnavailableError, path, err)
+	}
+	for _, controls := range s.values {
+		s.count += len(controls)
+	}
+	return s, nil
+}
+
+func (s *JSONFileStorage) Put(deviceId, controlId, value string) error {
+	return s.WriteBatch([]StorageOp{{DeviceId: deviceId, ControlId: controlId, Value: value}})
+}
+
+func (s *JSONFileStorage) Delete(deviceId, controlId string) error {
+	return s.WriteBatch([]StorageOp{{DeviceId: deviceId, ControlId: controlId, Delete: true}})
+}
+
+func (s *JSONFileStorage) WriteBatch(ops []StorageOp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.apply(ops) && !s.dirty {
+		return nil
+	}
+	s.dirty = true
+	data, err := json.MarshalIndent(s.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(s.path, data, s.mode); err != nil {
+		return fmt.Errorf("failed to write storage: %w", err)
+	}
+	s.dirty = false
+	s.written(len(data))
+	return nil
+}
+
+// storageRecord is a single append-only log record
+type storageRecord struct {
+	Op      string `json:"op"`
+	Device  string `json:"d"`
+	Control string `json:"c,omitempty"`
+	Value   string `json:"v,omitempty"`
+}
+
+const (
+	storageOpPut    = "put"
+	storageOpDelete = "del"
+
+	// DefaultCompactThreshold is a default number of stale log records
+	// which triggers AppendFileStorage compaction
+	DefaultCompactThreshold = 1000
+)
+
+func storageRecordFromOp(op StorageOp) storageRecord {
+	if op.Delete {
+		return storageRecord{Op: storageOpDelete, Device: op.DeviceId, Control: op.ControlId}
+	}
+	return storageRecord{storageOpPut, op.DeviceId, op.ControlId, op.Value}
+}
+
+// encodeStorageRecord encodes record as log line "<crc32> <json>\n"
+func encodeStorageRecord(buf *bytes.Buffer, r storageRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(buf, "%08x ", crc32.ChecksumIEEE(data))
+	buf.Write(data)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// decodeStorageRecord decodes log line. Lines without checksum
+// (plain JSON written by older versions) are accepted as legacy records
+func decodeStorageRecord(line []byte) (r storageRecord, legacy bool, err error) {
+	if bytes.HasPrefix(line, []byte{'{'}) {
+		err = json.Unmarshal(line, &r)
+		return r, true, err
+	}
+	sum, data, found := bytes.Cut(line, []byte{' '})
+	if !found {
+		return r, false, errors.New("malformed record")
+	}
+	expected, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil || uint32(expected) != crc32.ChecksumIEEE(data) {
+		return r, false, errors.New("checksum mismatch")
+	}
+	err = json.Unmarshal(data, &r)
+	return r, false, err
+}
+
+// AppendFileStorage appends each change to checksummed log file and replays it on open.
+// Incomplete or corrupted log tail (e.g. after power loss mid-write) is truncated.
+// Logs written by older versions (records without checksums) are read as is
+// and rewritten in current format on open.
+// Log is compacted when it has too many stale records
+type AppendFileStorage struct {
+	MemoryStorage
+	storageStats
+	path             string
+	mode             os.FileMode
+	file             *os.File
+	records          int
+	compactThreshold int
+}
+
+// NewAppendFileStorage opens append-only file storage and replays its log
+func NewAppendFileStorage(path string, mode os.FileMode) (*AppendFileStorage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, mode)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", StorageUnavailableError, err)
+	}
+	s := &AppendFileStorage{
+		MemoryStorage:    MemoryStorage{values: make(map[string]map[string]string)},
+		path:             path,
+		mode:             mode,
+		file:             file,
+		compactThreshold: DefaultCompactThreshold,
+	}
+	s.storageStats.init()
+	legacy, err := s.replay()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: failed to read %s: %v", StorageUnavailableError, path, err)
+	}
+	if legacy {
+		Info.Printf("storage %s: migrating log to checksummed format", path)
+		if err := s.compact(); err != nil {
+			s.file.Close()
+			return nil, fmt.Errorf("%w: failed to migrate %s: %v", StorageUnavailableError, path, err)
+		}
+	}
+	return s, nil
+}
+
+// SetCompactThreshold sets number of stale records which triggers compaction
+func (s *AppendFileStorage) SetCompactThreshold(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compactThreshold = n
+}
+
+// replay reads log, legacy is true if log has records without checksums
+func (s *AppendFileStorage) replay() (legacy bool, err error) {
+	reader := bufio.NewReader(s.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				Warn.Printf("storage %s: truncating incomplete record at offset %d", s.path, offset)
+				return legacy, s.file.Truncate(offset)
+			}
+			return legacy, nil
+		}
+		if err != nil {
+			return legacy, err
+		}
+		r, isLegacy, err := decodeStorageRecord(bytes.TrimSuffix(line, []byte{'\n'}))
+		if err != nil {
+			Warn.Printf("storage %s: truncating log at offset %d: %v", s.path, offset, err)
+			return legacy, s.file.Truncate(offset)
+		}
+		switch r.Op {
+		case storageOpPut:
+			s.put(r.Device, r.Control, r.Value)
+		case storageOpDelete:
+			s.delete(r.Device, r.Control)
+		default:
+			return legacy, fmt.Errorf("offset %d: unknown operation %q", offset, r.Op)
+		}
+		legacy = legacy || isLegacy
+		offset += int64(len(line))
+		s.records++
+	}
+}
+
+func (s *AppendFileStorage) Put(deviceId, controlId, value string) error {
+	return s.WriteBatch([]StorageOp{{DeviceId: deviceId, ControlId: controlId, Value: value}})
+}
+
+func (s *AppendFileStorage) Delete(deviceId, controlId string) error {
+	return s.WriteBatch([]StorageOp{{DeviceId: deviceId, ControlId: controlId, Delete: true}})
+}
+
+// WriteBatch appends all changes with single write and sync.
+// Values in memory are changed only after successful write
+func (s *AppendFileStorage) WriteBatch(ops []StorageOp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var buf bytes.Buffer
+	changed := make([]StorageOp, 0, len(ops))
+	for _, op := range ops {
+		if !op.Delete && s.has(op.DeviceId, op.ControlId, op.Value) {
+			continue
+		}
+		if err := encodeStorageRecord(&buf, storageRecordFromOp(op)); err != nil {
+			return err
+		}
+		changed = append(changed, op)
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write storage: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync storage: %w", err)
+	}
+	s.apply(changed)
+	s.written(buf.Len())
+	s.records += len(changed)
+	if s.records-s.count > s.compactThreshold {
+		return s.compact()
+	}
+	return nil
+}
+
+// Compact rewrites log with current values only
+func (s *AppendFileStorage) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+func (s *AppendFileStorage) compact() error {
+	var buf bytes.Buffer
+	var err error
+	records := 0
+	s.iterate("", func(deviceId, controlId, value string) bool {
+		err = encodeStorageRecord(&buf, storageRecord{storageOpPut, deviceId, controlId, value})
+		records++
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	// new file is opened before rename, so s.file always points to the live log
+	file, err := replaceFileAtomic(s.path, buf.Bytes(), s.mode)
+	if err != nil {
+		return fmt.Errorf("failed to compact storage: %w", err)
+	}
+	s.file.Close()
+	s.file = file
+	s.records = records
+	s.written(buf.Len())
+	s.compacted()
+	return nil
+}
+
+func (s *AppendFileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
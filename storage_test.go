@@ -0,0 +1,254 @@
+package wbgong
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type storageEntry struct {
+	device, control, value string
+}
+
+func storageEntries(t *testing.T, s Storage, deviceId string) []storageEntry {
+	var res []storageEntry
+	require.NoError(t, s.Iterate(deviceId, func(deviceId, controlId, value string) bool {
+		res = append(res, storageEntry{deviceId, controlId, value})
+		return true
+	}))
+	return res
+}
+
+func TestMemoryStorageIterateModify(t *testing.T) {
+	s := NewMemoryStorage()
+	require.NoError(t, s.Put("dev", "b", "2"))
+	require.NoError(t, s.Put("dev", "a", "1"))
+
+	// callback may modify storage
+	require.NoError(t, s.Iterate("", func(deviceId, controlId, value string) bool {
+		require.NoError(t, s.Put(deviceId, controlId+"_copy", value))
+		return true
+	}))
+	assert.Equal(t, []storageEntry{
+		{"dev", "a", "1"},
+		{"dev", "a_copy", "1"},
+		{"dev", "b", "2"},
+		{"dev", "b_copy", "2"},
+	}, storageEntries(t, s, "dev"))
+}
+
+func TestStorageReopen(t *testing.T) {
+	tests := []struct {
+		name string
+		open func(path string) (Storage, error)
+	}{
+		{"json", func(path string) (Storage, error) { return NewJSONFileStorage(path, 0644) }},
+		{"append", func(path string) (Storage, error) { return NewAppendFileStorage(path, 0644) }},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "storage")
+			s, err := tc.open(path)
+			require.NoError(t, err)
+			require.NoError(t, s.Put("dev1", "a", "1"))
+			require.NoError(t, s.Put("dev1", "a", "2"))
+			require.NoError(t, s.Put("dev1", "b", "x"))
+			require.NoError(t, s.Put("dev2", "c", "y"))
+			require.NoError(t, s.Delete("dev1", "b"))
+			require.NoError(t, s.Close())
+
+			s, err = tc.open(path)
+			require.NoError(t, err)
+			defer s.Close()
+			assert.Equal(t, []storageEntry{
+				{"dev1", "a", "2"},
+				{"dev2", "c", "y"},
+			}, storageEntries(t, s, ""))
+			_, err = s.Get("dev1", "b")
+			assert.ErrorIs(t, err, StorageValueNotFoundError)
+		})
+	}
+}
+
+func TestJSONFileStorageBadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0644))
+	_, err := NewJSONFileStorage(path, 0644)
+	assert.ErrorIs(t, err, StorageUnavailableError)
+}
+
+func TestAppendFileStorageReplay(t *testing.T) {
+	put1 := `{"op":"put","d":"dev","c":"a","v":"1"}`
+	put2 := `{"op":"put","d":"dev","c":"b","v":"2"}`
+	del1 := `{"op":"del","d":"dev","c":"a"}`
+	record := func(data string) string {
+		var buf bytes.Buffer
+		var r storageRecord
+		require.NoError(t, json.Unmarshal([]byte(data), &r))
+		require.NoError(t, encodeStorageRecord(&buf, r))
+		return buf.String()
+	}
+	tests := []struct {
+		name    string
+		log     string
+		entries []storageEntry
+		// expected prefix of log after open, empty to skip the check
+		rest string
+	}{
+		{
+			name:    "current format",
+			log:     record(put1) + record(put2) + record(del1),
+			entries: []storageEntry{{"dev", "b", "2"}},
+		},
+		{
+			name:    "legacy format",
+			log:     put1 + "\n" + put2 + "\n",
+			entries: []storageEntry{{"dev", "a", "1"}, {"dev", "b", "2"}},
+		},
+		{
+			name:    "legacy then current",
+			log:     put1 + "\n" + record(put2) + record(del1),
+			entries: []storageEntry{{"dev", "b", "2"}},
+		},
+		{
+			name:    "incomplete tail",
+			log:     record(put1) + record(put2)[:10],
+			entries: []storageEntry{{"dev", "a", "1"}},
+			rest:    record(put1),
+		},
+		{
+			name:    "corrupted tail",
+			log:     record(put1) + "00000000 " + put2 + "\n",
+			entries: []storageEntry{{"dev", "a", "1"}},
+			rest:    record(put1),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "storage.log")
+			require.NoError(t, os.WriteFile(path, []byte(tc.log), 0644))
+			s, err := NewAppendFileStorage(path, 0644)
+			require.NoError(t, err)
+			assert.Equal(t, tc.entries, storageEntries(t, s, ""))
+			require.NoError(t, s.Put("dev", "c", "3"))
+			require.NoError(t, s.Close())
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			for _, line := range strings.SplitAfter(string(data), "\n") {
+				if line == "" {
+					continue
+				}
+				_, legacy, err := decodeStorageRecord([]byte(strings.TrimSuffix(line, "\n")))
+				require.NoError(t, err)
+				assert.False(t, legacy, line)
+			}
+			if tc.rest != "" {
+				assert.True(t, strings.HasPrefix(string(data), tc.rest))
+			}
+
+			s, err = NewAppendFileStorage(path, 0644)
+			require.NoError(t, err)
+			defer s.Close()
+			assert.Equal(t, append(tc.entries, storageEntry{"dev", "c", "3"}), storageEntries(t, s, ""))
+		})
+	}
+}
+
+func TestAppendFileStorageCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.log")
+	s, err := NewAppendFileStorage(path, 0644)
+	require.NoError(t, err)
+	s.SetCompactThreshold(5)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, s.Put("dev", "a", strconv.Itoa(i)))
+		require.NoError(t, s.Put("dev", "b", "x"))
+	}
+	assert.NotZero(t, s.Stats().Compactions)
+	assert.LessOrEqual(t, s.records-s.Len(), 5)
+
+	// storage keeps writing to compacted file
+	require.NoError(t, s.Compact())
+	require.NoError(t, s.Put("dev", "c", "y"))
+	require.NoError(t, s.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), "\n"))
+
+	s, err = NewAppendFileStorage(path, 0644)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, []storageEntry{
+		{"dev", "a", "19"},
+		{"dev", "b", "x"},
+		{"dev", "c", "y"},
+	}, storageEntries(t, s, ""))
+}
+
+// failingStorage fails writes while fail is set
+type failingStorage struct {
+	*MemoryStorage
+	fail bool
+}
+
+func (s *failingStorage) WriteBatch(ops []StorageOp) error {
+	if s.fail {
+		return StorageUnavailableError
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apply(ops)
+	return nil
+}
+
+func TestBatchingStorageRequeue(t *testing.T) {
+	under := &failingStorage{MemoryStorage: NewMemoryStorage(), fail: true}
+	s := NewBatchingStorage(under, time.Hour)
+	defer s.Close()
+
+	require.NoError(t, s.Put("dev", "a", "1"))
+	require.NoError(t, s.Put("dev", "b", "1"))
+	assert.ErrorIs(t, s.Flush(), StorageUnavailableError)
+	assert.Equal(t, 2, s.Pending())
+
+	// newer value staged after failed flush wins
+	require.NoError(t, s.Put("dev", "a", "2"))
+	s.requeue([]StorageOp{{DeviceId: "dev", ControlId: "a", Value: "1"}})
+	value, err := s.Get("dev", "a")
+	require.NoError(t, err)
+	assert.Equal(t, "2", value)
+
+	under.fail = false
+	require.NoError(t, s.Flush())
+	assert.Zero(t, s.Pending())
+	assert.Equal(t, []storageEntry{{"dev", "a", "2"}, {"dev", "b", "1"}}, storageEntries(t, under, ""))
+}
+
+func TestBatchingStorageUnbatched(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		under := &failingStorage{MemoryStorage: NewMemoryStorage()}
+		s := NewBatchingStorage(under, interval)
+
+		require.NoError(t, s.Put("dev", "a", "1"))
+		require.NoError(t, s.Put("dev", "b", "1"))
+		require.NoError(t, s.Delete("dev", "b"))
+		assert.Zero(t, s.Pending())
+		assert.Equal(t, []storageEntry{{"dev", "a", "1"}}, storageEntries(t, under, ""))
+
+		under.fail = true
+		assert.ErrorIs(t, s.Put("dev", "a", "2"), StorageUnavailableError)
+		assert.Equal(t, 1, s.Pending(), "failed change is kept")
+		under.fail = false
+		require.NoError(t, s.Close())
+		assert.Equal(t, []storageEntry{{"dev", "a", "2"}}, storageEntries(t, under, ""))
+	}
+}
@@ -1,6 +1,7 @@
 package testutils
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -60,6 +61,20 @@ func (backend *FakeDriverBackend) SetFilter(f wbgong.DeviceFilter) <-chan struct
 	return c
 }
 
+func (backend *FakeDriverBackend) AddToFilter(f wbgong.DeviceFilter) <-chan struct{} {
+	backend.Rec("[FakeDriverBackend] AddToFilter(%T)", f)
+	c := make(chan struct{}, 1)
+	c <- struct{}{}
+	return c
+}
+
+func (backend *FakeDriverBackend) RemoveFromFilter(f wbgong.DeviceFilter) <-chan struct{} {
+	backend.Rec("[FakeDriverBackend] RemoveFromFilter(%T)", f)
+	c := make(chan struct{}, 1)
+	c <- struct{}{}
+	return c
+}
+
 func (backend *FakeDriverBackend) RemoveDevice(dev wbgong.LocalDevice) <-chan error {
 	backend.Rec("[FakeDriverBackend] RemoveDevice(%s)", dev.GetId())
 	return closedChan()
@@ -105,6 +120,16 @@ func (backend *FakeDriverBackend) UpdateControlMetaJson(control wbgong.Control)
 	return closedChan()
 }
 
+func (backend *FakeDriverBackend) ApplyControlBatch(b *wbgong.ControlBatch) <-chan error {
+	for _, v := range b.Values {
+		backend.Rec("[FakeDriverBackend] ApplyControlBatch(control %s/%s, value %s)", b.Device.GetId(), v.Control.GetId(), v.RawValue)
+	}
+	for _, m := range b.Metas {
+		backend.Rec("[FakeDriverBackend] ApplyControlBatch(control %s/%s, meta %s, value %v)", b.Device.GetId(), m.Control.GetId(), m.Meta, m.Value)
+	}
+	return closedChan()
+}
+
 func (backend *FakeDriverBackend) RemoveControl(ctrl wbgong.Control) <-chan error {
 	backend.Rec("[FakeDriverBackend] RemoveControl(control %s/%s)", ctrl.GetDevice().GetId(), ctrl.GetId())
 	return closedChan()
@@ -130,6 +155,7 @@ type FakeDriverFrontend struct {
 	id      string
 	backend wbgong.DriverBackend
 	doReown bool
+	filters *wbgong.FilterSet
 
 	Devices map[string]wbgong.Device
 }
@@ -138,6 +164,7 @@ func NewFakeDriverFrontend(id string, t *testing.T) *FakeDriverFrontend {
 	return &FakeDriverFrontend{
 		Recorder: NewRecorder(t),
 		id:       id,
+		filters:  wbgong.NewFilterSet(&wbgong.NoDevicesFilter{}),
 		Devices:  make(map[string]wbgong.Device),
 	}
 }
@@ -146,10 +173,67 @@ func (f *FakeDriverFrontend) GetId() string {
 	return f.id
 }
 
+// dummy
+func (f *FakeDriverFrontend) Stats() wbgong.DriverStats {
+	return wbgong.DriverStats{}
+}
+
+// dummy
+func (f *FakeDriverFrontend) ReadTx() wbgong.ReadTx {
+	return wbgong.NewReadState().Load()
+}
+
 func (f *FakeDriverFrontend) SetFilter(fl wbgong.DeviceFilter) {
+	f.filters = wbgong.NewFilterSet(fl)
 	<-f.backend.SetFilter(fl)
 }
 
+// filterChanged applies filter change with IncrementalFilterBackend if backend supports it,
+// otherwise whole filter set is reloaded
+func (f *FakeDriverFrontend) filterChanged(incremental func(b wbgong.IncrementalFilterBackend) <-chan struct{}) {
+	if b, ok := f.backend.(wbgong.IncrementalFilterBackend); ok {
+		<-incremental(b)
+		return
+	}
+	<-f.backend.SetFilter(wbgong.NewFilterSet(f.filters.Filters()...))
+}
+
+func (f *FakeDriverFrontend) AddToFilter(fl wbgong.DeviceFilter) {
+	if !f.filters.Add(fl) {
+		return
+	}
+	f.filterChanged(func(b wbgong.IncrementalFilterBackend) <-chan struct{} {
+		return b.AddToFilter(fl)
+	})
+}
+
+// RemoveFromFilter removes external devices and controls which don't match remaining filters
+func (f *FakeDriverFrontend) RemoveFromFilter(fl wbgong.DeviceFilter) {
+	if !f.filters.Remove(fl) {
+		return
+	}
+	f.filterChanged(func(b wbgong.IncrementalFilterBackend) <-chan struct{} {
+		return b.RemoveFromFilter(fl)
+	})
+	for id, dev := range f.Devices {
+		ext, ok := dev.(wbgong.ExternalDevice)
+		if !ok {
+			continue
+		}
+		if !wbgong.FilterMatchesDevice(f.filters, ext) {
+			f.backend.RemoveExternalDevice(ext)
+			delete(f.Devices, id)
+			continue
+		}
+		for _, ctrl := range ext.ControlsList() {
+			if !wbgong.FilterMatchesControl(f.filters, ctrl) {
+				f.backend.RemoveExternalControl(ctrl)
+				ext.RemoveControl(ctrl.GetId())
+			}
+		}
+	}
+}
+
 // dummy
 func (f *FakeDriverFrontend) SetLocalDeviceFactory(ff wbgong.LocalDeviceFactory) wbgong.LocalDeviceFactory {
 	return nil
@@ -171,6 +255,11 @@ func (f *FakeDriverFrontend) OnRetainReady(ff func(tx wbgong.DriverTx)) {}
 // dummy
 func (f *FakeDriverFrontend) WaitForReady() {}
 
+// dummy
+func (f *FakeDriverFrontend) WaitForReadyContext(ctx context.Context) error {
+	return nil
+}
+
 // dummy
 func (f *FakeDriverFrontend) OnDriverEvent(ff func(e wbgong.DriverEvent)) wbgong.HandlerID {
 	return 0
@@ -179,11 +268,48 @@ func (f *FakeDriverFrontend) OnDriverEvent(ff func(e wbgong.DriverEvent)) wbgong
 // dummy
 func (f *FakeDriverFrontend) RemoveOnDriverEventHandler(i wbgong.HandlerID) {}
 
+func (f *FakeDriverFrontend) Events(opts wbgong.EventStreamOptions) *wbgong.EventStream {
+	return wbgong.NewEventStream(f, opts)
+}
+
+// dummy
+func (f *FakeDriverFrontend) OnControlValue(p string, ff func(e wbgong.ControlValueEvent)) (wbgong.HandlerID, error) {
+	return 0, nil
+}
+
+// dummy
+func (f *FakeDriverFrontend) OnControlOn(p string, ff func(e wbgong.ControlOnValueEvent)) (wbgong.HandlerID, error) {
+	return 0, nil
+}
+
+// dummy
+func (f *FakeDriverFrontend) OnNewExternalDevice(p string, ff func(e wbgong.NewExternalDeviceEvent)) (wbgong.HandlerID, error) {
+	return 0, nil
+}
+
+// dummy
+func (f *FakeDriverFrontend) OnMeta(p, t string, ff func(e wbgong.DriverEvent)) (wbgong.HandlerID, error) {
+	return 0, nil
+}
+
+// dummy
+func (f *FakeDriverFrontend) AddOnValueInterceptor(ff wbgong.OnValueInterceptor) wbgong.HandlerID {
+	return 0
+}
+
+// dummy
+func (f *FakeDriverFrontend) RemoveOnValueInterceptor(i wbgong.HandlerID) {}
+
 // dummy
 func (f *FakeDriverFrontend) LoopOnce(timeout time.Duration) bool {
 	return false
 }
 
+// dummy
+func (f *FakeDriverFrontend) LoopContext(ctx context.Context) error {
+	return nil
+}
+
 // dummy
 func (f *FakeDriverFrontend) StartLoop() error {
 	return nil
@@ -205,6 +331,11 @@ func (f *FakeDriverFrontend) AccessAsync(thunk func(tx wbgong.DriverTx) error) f
 	}
 }
 
+// dummy
+func (f *FakeDriverFrontend) AccessContext(ctx context.Context, thunk func(tx wbgong.DriverTx) error) error {
+	return nil
+}
+
 // dummy
 func (f *FakeDriverFrontend) BeginTx() (wbgong.DriverTx, error) {
 	return nil, nil
@@ -45,6 +45,15 @@ func FormatMQTTMessage(message wbgong.MQTTMessage) string {
 type SubscriptionList []*FakeMQTTClient
 type SubscriptionMap map[string]SubscriptionList
 
+func (subs SubscriptionList) contains(client *FakeMQTTClient) bool {
+	for _, c := range subs {
+		if c == client {
+			return true
+		}
+	}
+	return false
+}
+
 type dispatchedMessage struct {
 	client  *FakeMQTTClient
 	message wbgong.MQTTMessage
@@ -152,16 +161,12 @@ func (broker *FakeMQTTBroker) Subscribe(client *FakeMQTTClient, topic string) {
 	subs, found := broker.subscriptions[topic]
 	if !found {
 		broker.subscriptions[topic] = SubscriptionList{client}
-	} else {
-		for _, c := range subs {
-			if c == client {
-				return
-			}
-		}
+	} else if !subs.contains(client) {
 		broker.subscriptions[topic] = append(subs, client)
 	}
 
-	// send all retained messages for this subscription
+	// send all retained messages for this subscription,
+	// they're resent if client subscribes to the same topic again
 	for t, message := range broker.retained {
 		if topicMatch(topic, t) {
 			broker.Rec("(retain) -> %s: %s", message.Topic, FormatMQTTMessage(message))
@@ -295,13 +300,9 @@ func (client *FakeMQTTClient) Subscribe(callback wbgong.MQTTMessageHandler, topi
 	defer client.Unlock()
 	client.ensureStarted()
 	for _, topic := range topics {
+		// like real clients, subscription to the same topic replaces its handler
+		client.callbackMap[topic] = []wbgong.MQTTMessageHandler{callback}
 		client.broker.Subscribe(client, topic)
-		handlerList, found := client.callbackMap[topic]
-		if found {
-			client.callbackMap[topic] = append(handlerList, callback)
-		} else {
-			client.callbackMap[topic] = []wbgong.MQTTMessageHandler{callback}
-		}
 	}
 }
 
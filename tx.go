@@ -1,10 +1,32 @@
 package wbgong
 
 // DriverTx is a transaction interface
+//
+// Changes made through transaction (created/removed devices and controls,
+// values and meta updates) are staged and published only on Commit.
+// Rollback discards staged changes, so no retained topics are left behind.
+// If Commit fails, already published topics are cleared and changes are discarded.
+//
+// Futures returned by transaction methods are resolved when change is applied locally,
+// not when it's published, so it's safe to wait for them inside transaction
+// (e.g. tx.CreateDevice(args)()). Publication errors are returned by Commit
 type DriverTx interface {
-	// End() closes transaction and makes it invalid
+	// End() closes transaction and makes it invalid.
+	// Uncommitted changes are committed
 	End()
 
+	// Commit publishes all staged changes and closes transaction.
+	// Returns TxDoneError if transaction is already finished
+	Commit() error
+
+	// Rollback discards all staged changes and closes transaction.
+	// Devices and controls created in transaction are removed
+	// without publishing anything
+	Rollback()
+
+	// IsDone checks whether transaction is committed or rolled back
+	IsDone() bool
+
 	// GetDevicesList returns a slice of devices
 	// currently registered in driver
 	GetDevicesList() []Device
@@ -57,6 +79,11 @@ type DeviceDriverTx interface {
 	UpdateDeviceMeta(dev LocalDevice, meta string, value any) func() error
 	UpdateDeviceMetaJson(dev LocalDevice) func() error
 
+	// ApplyControlBatch applies value and meta changes of single device as one unit:
+	// controls are updated, backend publishes changes back to back
+	// and local subscribers get ControlBatch.Events() if notification flag is set
+	ApplyControlBatch(b *ControlBatch, notifySubs bool) func() error
+
 	// SetOnValue sends /on value for given control
 	// SetOnValue is a future request
 	SetOnValue(control Control, rawValue string) func() error
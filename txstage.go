@@ -0,0 +1,156 @@
+package wbgong
+
+// TxState is a transaction lifecycle state
+type TxState int
+
+const (
+	TxActive TxState = iota
+	TxCommitted
+	TxRolledBack
+)
+
+type txChange struct {
+	publish   func() error
+	unpublish func()
+	discard   func()
+}
+
+// TxStage collects changes made in transaction until it's finished.
+// Driver implementations apply changes to local state immediately
+// and stage their publication: on Commit changes are published in order,
+// on Rollback local changes are undone in reverse order.
+//
+// Futures returned by transaction methods must not wait for Commit:
+// they're resolved as soon as local change is applied (see StageFuture),
+// otherwise thunks waiting for them (e.g. tx.CreateDevice(args)()) would deadlock.
+// Publication errors are returned by Commit.
+//
+// TxStage is not thread-safe, it's used in driver loop only
+type TxStage struct {
+	changes []txChange
+	state   TxState
+}
+
+// NewTxStage creates empty active stage
+func NewTxStage() *TxStage {
+	return &TxStage{}
+}
+
+// State returns current transaction state
+func (s *TxStage) State() TxState {
+	return s.state
+}
+
+// IsDone checks whether transaction is committed or rolled back
+func (s *TxStage) IsDone() bool {
+	return s.state != TxActive
+}
+
+// Len returns number of staged changes
+func (s *TxStage) Len() int {
+	return len(s.changes)
+}
+
+// Err returns error for operations on finished transaction
+func (s *TxStage) Err() error {
+	switch s.state {
+	case TxCommitted:
+		return TxDoneError
+	case TxRolledBack:
+		return TxRolledBackError
+	}
+	return nil
+}
+
+// Stage adds change to transaction. Publish is called on commit,
+// discard is called on rollback. Both may be nil
+func (s *TxStage) Stage(publish func() error, discard func()) error {
+	return s.StageRetained(publish, nil, discard)
+}
+
+// StageRetained adds change which publishes retained topics. Unpublish must clear
+// topics published by publish, it's called if this or later change fails on commit.
+// Any function may be nil
+func (s *TxStage) StageRetained(publish func() error, unpublish func(), discard func()) error {
+	if err := s.Err(); err != nil {
+		return err
+	}
+	s.changes = append(s.changes, txChange{publish, unpublish, discard})
+	return nil
+}
+
+// StageFuture applies local change immediately and stages its publication.
+// Returned future is resolved with apply result and doesn't wait for Commit,
+// so it's safe to wait for it inside transaction
+func (s *TxStage) StageFuture(apply func() error, publish func() error, unpublish func(), discard func()) FuncError {
+	if err := s.Err(); err != nil {
+		return MakeFuncError(err)
+	}
+	if err := apply(); err != nil {
+		return MakeFuncError(err)
+	}
+	return MakeFuncError(s.StageRetained(publish, unpublish, discard))
+}
+
+// Commit publishes staged changes in order.
+// If some change fails to publish, topics published so far are cleared
+// in reverse order (including failed change, which may have published some
+// of its topics), all local changes are discarded, transaction
+// is marked as rolled back and error is returned
+func (s *TxStage) Commit() error {
+	if err := s.Err(); err != nil {
+		return err
+	}
+	s.state = TxCommitted
+	changes := s.changes
+	s.changes = nil
+	for i, c := range changes {
+		if c.publish == nil {
+			continue
+		}
+		if err := c.publish(); err != nil {
+			s.state = TxRolledBack
+			unpublishChanges(changes[:i+1])
+			discardChanges(changes)
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback discards staged changes in reverse order.
+// Rollback of finished transaction does nothing
+func (s *TxStage) Rollback() {
+	if s.IsDone() {
+		return
+	}
+	s.state = TxRolledBack
+	discardChanges(s.changes)
+	s.changes = nil
+}
+
+// Finish commits stage if err is nil and rolls it back otherwise.
+// Returns err or commit error
+func (s *TxStage) Finish(err error) error {
+	if err != nil {
+		s.Rollback()
+		return err
+	}
+	return s.Commit()
+}
+
+func unpublishChanges(changes []txChange) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].unpublish != nil {
+			changes[i].unpublish()
+		}
+	}
+}
+
+func discardChanges(changes []txChange) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].discard != nil {
+			changes[i].discard()
+		}
+	}
+}
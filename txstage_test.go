@@ -0,0 +1,103 @@
+package wbgong
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stageLog records calls of staged functions
+type stageLog []string
+
+func (l *stageLog) publish(name string, err error) func() error {
+	return func() error {
+		*l = append(*l, "publish "+name)
+		return err
+	}
+}
+
+func (l *stageLog) call(action, name string) func() {
+	return func() {
+		*l = append(*l, action+" "+name)
+	}
+}
+
+func (l *stageLog) stage(s *TxStage, name string, err error) error {
+	return s.StageRetained(l.publish(name, err), l.call("unpublish", name), l.call("discard", name))
+}
+
+func TestTxStageCommit(t *testing.T) {
+	var log stageLog
+	s := NewTxStage()
+	assert.NoError(t, log.stage(s, "a", nil))
+	assert.NoError(t, s.Stage(nil, nil))
+	assert.NoError(t, log.stage(s, "b", nil))
+	assert.Equal(t, 3, s.Len())
+	assert.Empty(t, log, "nothing is published before commit")
+
+	assert.NoError(t, s.Commit())
+	assert.Equal(t, stageLog{"publish a", "publish b"}, log)
+	assert.Equal(t, TxCommitted, s.State())
+	assert.True(t, s.IsDone())
+	assert.ErrorIs(t, s.Commit(), TxDoneError)
+	assert.ErrorIs(t, log.stage(s, "c", nil), TxDoneError)
+}
+
+func TestTxStageCommitFailure(t *testing.T) {
+	var log stageLog
+	failure := errors.New("publish failed")
+	s := NewTxStage()
+	log.stage(s, "a", nil)
+	log.stage(s, "b", failure)
+	log.stage(s, "c", nil)
+
+	assert.ErrorIs(t, s.Commit(), failure)
+	assert.Equal(t, stageLog{
+		"publish a", "publish b",
+		"unpublish b", "unpublish a",
+		"discard c", "discard b", "discard a",
+	}, log)
+	assert.Equal(t, TxRolledBack, s.State())
+	assert.ErrorIs(t, s.Commit(), TxRolledBackError)
+}
+
+func TestTxStageRollback(t *testing.T) {
+	var log stageLog
+	s := NewTxStage()
+	log.stage(s, "a", nil)
+	log.stage(s, "b", nil)
+
+	err := errors.New("tx failed")
+	assert.ErrorIs(t, s.Finish(err), err)
+	assert.Equal(t, stageLog{"discard b", "discard a"}, log)
+	assert.Equal(t, TxRolledBack, s.State())
+
+	log = nil
+	s.Rollback()
+	assert.Empty(t, log, "second rollback does nothing")
+	assert.ErrorIs(t, log.stage(s, "c", nil), TxRolledBackError)
+}
+
+func TestTxStageFuture(t *testing.T) {
+	var log stageLog
+	s := NewTxStage()
+
+	applied := false
+	f := s.StageFuture(func() error {
+		applied = true
+		return nil
+	}, log.publish("a", nil), log.call("unpublish", "a"), log.call("discard", "a"))
+	assert.True(t, applied, "local change is applied immediately")
+	assert.NoError(t, f(), "future doesn't wait for commit")
+	assert.Empty(t, log)
+
+	failure := errors.New("apply failed")
+	f = s.StageFuture(func() error { return failure }, log.publish("b", nil), nil, nil)
+	assert.ErrorIs(t, f(), failure)
+	assert.Equal(t, 1, s.Len(), "failed change is not staged")
+
+	assert.NoError(t, s.Finish(nil))
+	assert.Equal(t, stageLog{"publish a"}, log)
+	assert.ErrorIs(t, s.StageFuture(func() error { return nil }, nil, nil, nil)(), TxDoneError)
+}
@@ -0,0 +1,218 @@
+package wbgong
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RGB is a value of 'rgb' control (published as "R;G;B")
+type RGB struct {
+	R, G, B uint8
+}
+
+func (c RGB) String() string {
+	return fmt.Sprintf("%d;%d;%d", c.R, c.G, c.B)
+}
+
+// ParseRGB parses 'rgb' control raw value
+func ParseRGB(raw string) (RGB, error) {
+	parts := strings.Split(raw, ";")
+	if len(parts) != 3 {
+		return RGB{}, fmt.Errorf("%w: bad rgb value '%s'", WrongValueError, raw)
+	}
+	var rgb [3]uint8
+	for i, part := range parts {
+		v, err := strconv.ParseUint(strings.TrimSpace(part), 10, 8)
+		if err != nil {
+			return RGB{}, fmt.Errorf("%w: bad rgb value '%s'", WrongValueError, raw)
+		}
+		rgb[i] = uint8(v)
+	}
+	return RGB{rgb[0], rgb[1], rgb[2]}, nil
+}
+
+// TypedValue is a set of Go types controls values can be represented with
+type TypedValue interface {
+	bool | float64 | string | RGB
+}
+
+// TypedControl wraps Control providing compile-time typed access to its value.
+// Control type is checked on construction, see NewTypedControl
+type TypedControl[T TypedValue] struct {
+	Control
+}
+
+// Handy aliases for common control types
+type (
+	BoolControl   = TypedControl[bool]
+	FloatControl  = TypedControl[float64]
+	StringControl = TypedControl[string]
+	RGBControl    = TypedControl[RGB]
+)
+
+// TypedControlValueHandler is a typed version of ControlValueHandler
+type TypedControlValueHandler[T TypedValue] func(control *TypedControl[T], value, prevValue T, tx DriverTx) error
+
+// NewTypedControl wraps control checking that its type can be represented with T:
+// bool - 'switch' and 'alarm', float64 - 'value', 'range' and value-derived types,
+// RGB - 'rgb', string - any type except pushbutton
+func NewTypedControl[T TypedValue](control Control) (*TypedControl[T], error) {
+	if control == nil {
+		return nil, NoSuchControlError
+	}
+	typestr := control.GetType()
+	if !typeMatchesValue[T](typestr) {
+		var zero T
+		return nil, fmt.Errorf("%w: control %s/%s has type '%s', can't use it as %T",
+			WrongControlTypeError, control.GetDevice().GetId(), control.GetId(), typestr, zero)
+	}
+	return &TypedControl[T]{control}, nil
+}
+
+func typeMatchesValue[T TypedValue](typestr string) bool {
+	var zero T
+	switch any(zero).(type) {
+	case bool:
+		return controlDataType(typestr) == CONV_DATATYPE_BOOLEAN
+	case float64:
+		return controlDataType(typestr) == CONV_DATATYPE_FLOAT
+	case RGB:
+		return typestr == CONV_TYPE_RGB
+	default:
+		return TypeToDataType(typestr) != CONV_DATATYPE_BUTTON
+	}
+}
+
+// controlDataType uses TypeToDataType table, types missing in it
+// are checked with plugin (see pluginDataType)
+func controlDataType(typestr string) ControlDataType {
+	dataType := TypeToDataType(typestr)
+	if dataType == CONV_DEFAULT_DATATYPE && typestr != CONV_TYPE_TEXT && typestr != CONV_TYPE_RGB {
+		dataType = pluginDataType(typestr)
+	}
+	return dataType
+}
+
+// pluginDataType detects data type by plugin conversion of type default value
+// (see GetDefaultValue, ToTypedValue)
+func pluginDataType(typestr string) ControlDataType {
+	raw, err := GetDefaultValue(typestr)
+	if err != nil {
+		return CONV_DEFAULT_DATATYPE
+	}
+	v, err := ToTypedValue(raw, typestr)
+	if err != nil {
+		return CONV_DEFAULT_DATATYPE
+	}
+	switch v.(type) {
+	case bool:
+		return CONV_DATATYPE_BOOLEAN
+	case float64, float32, int, int64:
+		return CONV_DATATYPE_FLOAT
+	default:
+		return CONV_DEFAULT_DATATYPE
+	}
+}
+
+// typedFromRaw converts raw MQTT value to T using plugin conversion (see ToTypedValue).
+// Empty raw value is converted to zero value
+func typedFromRaw[T TypedValue](raw, typestr string) (T, error) {
+	var zero T
+	switch any(zero).(type) {
+	case string:
+		return any(raw).(T), nil
+	case RGB:
+		if raw == "" {
+			return zero, nil
+		}
+		rgb, err := ParseRGB(raw)
+		return any(rgb).(T), err
+	}
+	if raw == "" {
+		return zero, nil
+	}
+	v, err := ToTypedValue(raw, typestr)
+	if err != nil {
+		return zero, fmt.Errorf("%w: %v", WrongValueError, err)
+	}
+	return typedFromConverted[T](v)
+}
+
+// typedFromConverted converts value returned by plugin conversion to T
+func typedFromConverted[T TypedValue](v any) (T, error) {
+	var zero T
+	switch tv := v.(type) {
+	case T:
+		return tv, nil
+	case nil:
+		return zero, nil
+	}
+	if _, ok := any(zero).(float64); ok {
+		switch n := v.(type) {
+		case int:
+			return any(float64(n)).(T), nil
+		case int64:
+			return any(float64(n)).(T), nil
+		case float32:
+			return any(float64(n)).(T), nil
+		}
+	}
+	return zero, fmt.Errorf("%w: got %T, expected %T", WrongValueTypeError, v, zero)
+}
+
+// typedFromAny converts value passed to ControlValueHandler to T
+func typedFromAny[T TypedValue](v any, typestr string) (T, error) {
+	if raw, ok := v.(string); ok {
+		return typedFromRaw[T](raw, typestr)
+	}
+	return typedFromConverted[T](v)
+}
+
+// typedToArg converts T to value accepted by Control.UpdateValue and Control.SetOnValue
+func typedToArg[T TypedValue](v T) any {
+	if rgb, ok := any(v).(RGB); ok {
+		return rgb.String()
+	}
+	return v
+}
+
+// Get returns current control value (zero value if control has no value yet)
+func (c *TypedControl[T]) Get() (T, error) {
+	return typedFromRaw[T](c.GetRawValue(), c.GetType())
+}
+
+// Set updates local control value and notifies subscribers if flag is set
+func (c *TypedControl[T]) Set(v T, notifySubs bool) FuncError {
+	return c.UpdateValue(typedToArg(v), notifySubs)
+}
+
+// SetOn sends '/on' value to external control
+func (c *TypedControl[T]) SetOn(v T) FuncError {
+	return c.SetOnValue(typedToArg(v))
+}
+
+func (c *TypedControl[T]) wrapHandler(f TypedControlValueHandler[T]) ControlValueHandler {
+	return func(control Control, value, prevValue any, tx DriverTx) error {
+		typestr := control.GetType()
+		v, err := typedFromAny[T](value, typestr)
+		if err != nil {
+			return err
+		}
+		prev, err := typedFromAny[T](prevValue, typestr)
+		if err != nil {
+			return err
+		}
+		return f(c, v, prev, tx)
+	}
+}
+
+// OnChange sets value update handler (for external controls only)
+func (c *TypedControl[T]) OnChange(f TypedControlValueHandler[T]) error {
+	return c.SetValueUpdateHandler(c.wrapHandler(f))
+}
+
+// OnSetOn sets 'on' value handler (for local controls only)
+func (c *TypedControl[T]) OnSetOn(f TypedControlValueHandler[T]) error {
+	return c.SetOnValueReceiveHandler(c.wrapHandler(f))
+}
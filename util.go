@@ -2,6 +2,7 @@ package wbgong
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
@@ -117,3 +118,49 @@ func GetStack() string {
 	n := runtime.Stack(buf, true)
 	return string(buf[0:n])
 }
+
+// WriteFileAtomic writes file via temporary file and rename,
+// so file is either old or new even after power loss
+func WriteFileAtomic(path string, data []byte, mode os.FileMode) error {
+	f, err := replaceFileAtomic(path, data, mode)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// replaceFileAtomic works like WriteFileAtomic, but returns new file opened
+// for writing at its end, so caller never has to reopen replaced file
+func replaceFileAtomic(path string, data []byte, mode os.FileMode) (*os.File, error) {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTempFile(tmp, data, mode); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	// make rename durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return tmp, nil
+}
+
+func writeTempFile(tmp *os.File, data []byte, mode os.FileMode) error {
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+	return tmp.Sync()
+}